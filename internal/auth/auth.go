@@ -0,0 +1,101 @@
+// internal/auth/auth.go
+
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"product-catalogue-Telkom-LKPP/internal/logging"
+	"product-catalogue-Telkom-LKPP/internal/problem"
+	"product-catalogue-Telkom-LKPP/internal/repositories"
+)
+
+// Principal identifies the caller of a request
+type Principal struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Roles       []Role       `json:"roles"`
+	Permissions []Permission `json:"permissions,omitempty"` // Granted directly, e.g. to API keys
+	APIKeyID    string       `json:"api_key_id,omitempty"`  // Set when the caller authenticated with an API key
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext returns the principal stored in ctx, if any
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(contextKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// Middleware authenticates API keys sent in X-API-Key and bearer tokens, and
+// stores the caller in the request context. Reads stay public, while every
+// other method requires valid credentials.
+func Middleware(validator *JWTValidator, apiKeys APIKeyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if key := r.Header.Get("X-API-Key"); key != "" {
+				principal, err := authenticateAPIKey(r.Context(), apiKeys, key)
+				if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errAPIKeyInactive) {
+					logging.FromContext(r.Context()).Info("rejected API key", "error", err)
+					unauthorized(w, r, "Invalid API key")
+					return
+				}
+				if err != nil {
+					// The key may well be valid, so do not tell the client otherwise
+					logging.FromContext(r.Context()).Error("failed to look up API key", "error", err)
+					if repositories.IsTimeout(err) {
+						problem.Write(w, r, http.StatusGatewayTimeout, problem.CodeQueryTimeout, "Timed out checking the API key")
+						return
+					}
+					problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Failed to check the API key")
+					return
+				}
+
+				r = r.WithContext(NewContext(r.Context(), principal))
+			} else if header != "" {
+				scheme, tokenString, found := strings.Cut(header, " ")
+				if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(tokenString) == "" {
+					unauthorized(w, r, "Invalid authorization header")
+					return
+				}
+
+				principal, err := validator.Validate(strings.TrimSpace(tokenString))
+				if err != nil {
+					logging.FromContext(r.Context()).Info("rejected bearer token", "error", err)
+					unauthorized(w, r, "Invalid bearer token")
+					return
+				}
+
+				r = r.WithContext(NewContext(r.Context(), principal))
+			}
+
+			if !isReadOnly(r.Method) {
+				if _, ok := FromContext(r.Context()); !ok {
+					unauthorized(w, r, "Authentication required")
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isReadOnly(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="product-catalogue"`)
+	problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, message)
+}
@@ -0,0 +1,193 @@
+// internal/auth/jwt.go
+
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTConfig holds the locally configured keys used to verify bearer tokens
+type JWTConfig struct {
+	HMACSecret       string // Shared secret for HS256 tokens
+	RSAPublicKeyFile string // PEM encoded public key for RS256 tokens
+	JWKSFile         string // JWKS document with RS256 keys selected by "kid"
+	Issuer           string // Expected "iss" claim, if set
+	Audience         string // Expected "aud" claim, if set
+}
+
+// JWTValidator verifies HS256 and RS256 bearer tokens
+type JWTValidator struct {
+	hmacSecret []byte
+	rsaKey     *rsa.PublicKey
+	jwks       map[string]*rsa.PublicKey
+	parser     *jwt.Parser
+}
+
+type tokenClaims struct {
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Roles             []string `json:"roles"`
+	jwt.RegisteredClaims
+}
+
+// NewJWTValidator loads the configured keys, at least one of which is required
+func NewJWTValidator(cfg JWTConfig) (*JWTValidator, error) {
+	v := &JWTValidator{}
+
+	if cfg.HMACSecret != "" {
+		v.hmacSecret = []byte(cfg.HMACSecret)
+	}
+
+	if cfg.RSAPublicKeyFile != "" {
+		pemData, err := os.ReadFile(cfg.RSAPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read RSA public key: %v", err)
+		}
+
+		v.rsaKey, err = jwt.ParseRSAPublicKeyFromPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse RSA public key: %v", err)
+		}
+	}
+
+	if cfg.JWKSFile != "" {
+		jwks, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.jwks = jwks
+	}
+
+	if v.hmacSecret == nil && v.rsaKey == nil && len(v.jwks) == 0 {
+		return nil, errors.New("no JWT verification key configured")
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(options...)
+
+	return v, nil
+}
+
+// Validate verifies the token and returns the principal it identifies
+func (v *JWTValidator) Validate(tokenString string) (*Principal, error) {
+	var claims tokenClaims
+
+	_, err := v.parser.ParseWithClaims(tokenString, &claims, v.key)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.PreferredUsername
+	}
+
+	// Callers without explicit roles are treated as buyers
+	roles := []Role{RoleBuyer}
+	if len(claims.Roles) > 0 {
+		roles = make([]Role, 0, len(claims.Roles))
+		for _, role := range claims.Roles {
+			roles = append(roles, Role(role))
+		}
+	}
+
+	return &Principal{
+		ID:    claims.Subject,
+		Name:  name,
+		Roles: roles,
+	}, nil
+}
+
+// key selects the verification key matching the token's signing method
+func (v *JWTValidator) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		if v.hmacSecret == nil {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		return v.hmacSecret, nil
+
+	case jwt.SigningMethodRS256.Alg():
+		if kid, ok := token.Header["kid"].(string); ok && v.jwks != nil {
+			if key, found := v.jwks[kid]; found {
+				return key, nil
+			}
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		if v.rsaKey == nil {
+			return nil, errors.New("RS256 tokens are not accepted")
+		}
+		return v.rsaKey, nil
+	}
+
+	return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+}
+
+// loadJWKS reads the RSA signing keys of a JWKS document indexed by key ID
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %v", err)
+	}
+
+	var document struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range document.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus for key %q: %v", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent for key %q: %v", jwk.Kid, err)
+		}
+
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS file contains no RSA signing keys")
+	}
+
+	return keys, nil
+}
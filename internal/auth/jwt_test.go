@@ -0,0 +1,342 @@
+// internal/auth/jwt_test.go
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testHMACSecret = "test-secret-of-at-least-32-bytes!"
+
+func generateRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	return key
+}
+
+// writeFile writes data to a file in a temporary directory and returns its path
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+	return path
+}
+
+func writePublicKeyPEM(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("marshalling public key: %v", err)
+	}
+	return writeFile(t, "public.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func writeJWKS(t *testing.T, keys map[string]*rsa.PrivateKey) string {
+	t.Helper()
+
+	type jwk struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	}
+	var document struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, key := range keys {
+		document.Keys = append(document.Keys, jwk{
+			Kid: kid,
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+
+	data, err := json.Marshal(document)
+	if err != nil {
+		t.Fatalf("marshalling JWKS: %v", err)
+	}
+	return writeFile(t, "jwks.json", data)
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "seller-1",
+		"name":  "Seller One",
+		"roles": []string{"seller"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func withClaims(change func(jwt.MapClaims)) jwt.MapClaims {
+	claims := validClaims()
+	change(claims)
+	return claims
+}
+
+func TestJWTValidatorValidate(t *testing.T) {
+	rsaKey := generateRSAKey(t)
+	jwksKey := generateRSAKey(t)
+	otherKey := generateRSAKey(t)
+
+	pemFile := writePublicKeyPEM(t, rsaKey)
+	jwksFile := writeJWKS(t, map[string]*rsa.PrivateKey{"k1": jwksKey})
+
+	tests := []struct {
+		name    string
+		config  JWTConfig
+		token   string
+		wantErr bool
+	}{
+		{
+			name:   "HS256 with the shared secret",
+			config: JWTConfig{HMACSecret: testHMACSecret},
+			token:  signToken(t, jwt.SigningMethodHS256, []byte(testHMACSecret), "", validClaims()),
+		},
+		{
+			name:    "HS256 signed with another secret",
+			config:  JWTConfig{HMACSecret: testHMACSecret},
+			token:   signToken(t, jwt.SigningMethodHS256, []byte("another-secret-of-at-least-32-bytes"), "", validClaims()),
+			wantErr: true,
+		},
+		{
+			// Signing with the public key as HMAC secret is the classic
+			// algorithm confusion attack
+			name:    "HS256 when only an RSA key is configured",
+			config:  JWTConfig{RSAPublicKeyFile: pemFile},
+			token:   signToken(t, jwt.SigningMethodHS256, []byte(testHMACSecret), "", validClaims()),
+			wantErr: true,
+		},
+		{
+			name:   "RS256 with the configured public key",
+			config: JWTConfig{RSAPublicKeyFile: pemFile},
+			token:  signToken(t, jwt.SigningMethodRS256, rsaKey, "", validClaims()),
+		},
+		{
+			name:    "RS256 signed with another key",
+			config:  JWTConfig{RSAPublicKeyFile: pemFile},
+			token:   signToken(t, jwt.SigningMethodRS256, otherKey, "", validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "RS256 when only a shared secret is configured",
+			config:  JWTConfig{HMACSecret: testHMACSecret},
+			token:   signToken(t, jwt.SigningMethodRS256, rsaKey, "", validClaims()),
+			wantErr: true,
+		},
+		{
+			name:   "RS256 with a JWKS key ID",
+			config: JWTConfig{JWKSFile: jwksFile},
+			token:  signToken(t, jwt.SigningMethodRS256, jwksKey, "k1", validClaims()),
+		},
+		{
+			name:    "RS256 with an unknown key ID",
+			config:  JWTConfig{JWKSFile: jwksFile, RSAPublicKeyFile: pemFile},
+			token:   signToken(t, jwt.SigningMethodRS256, rsaKey, "unknown", validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "unsupported signing method",
+			config:  JWTConfig{HMACSecret: testHMACSecret},
+			token:   signToken(t, jwt.SigningMethodHS512, []byte(testHMACSecret), "", validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "unsigned token",
+			config:  JWTConfig{HMACSecret: testHMACSecret},
+			token:   signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", validClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "missing exp",
+			config:  JWTConfig{HMACSecret: testHMACSecret},
+			token:   signToken(t, jwt.SigningMethodHS256, []byte(testHMACSecret), "", withClaims(func(c jwt.MapClaims) { delete(c, "exp") })),
+			wantErr: true,
+		},
+		{
+			name:    "missing sub",
+			config:  JWTConfig{HMACSecret: testHMACSecret},
+			token:   signToken(t, jwt.SigningMethodHS256, []byte(testHMACSecret), "", withClaims(func(c jwt.MapClaims) { delete(c, "sub") })),
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			config:  JWTConfig{HMACSecret: testHMACSecret},
+			token:   signToken(t, jwt.SigningMethodHS256, []byte(testHMACSecret), "", withClaims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() })),
+			wantErr: true,
+		},
+		{
+			name:    "wrong issuer",
+			config:  JWTConfig{HMACSecret: testHMACSecret, Issuer: "https://issuer.example"},
+			token:   signToken(t, jwt.SigningMethodHS256, []byte(testHMACSecret), "", withClaims(func(c jwt.MapClaims) { c["iss"] = "https://other.example" })),
+			wantErr: true,
+		},
+		{
+			name:    "wrong audience",
+			config:  JWTConfig{HMACSecret: testHMACSecret, Audience: "product-catalogue"},
+			token:   signToken(t, jwt.SigningMethodHS256, []byte(testHMACSecret), "", withClaims(func(c jwt.MapClaims) { c["aud"] = "other-service" })),
+			wantErr: true,
+		},
+		{
+			name:    "malformed token",
+			config:  JWTConfig{HMACSecret: testHMACSecret},
+			token:   "not.a.token",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator, err := NewJWTValidator(tt.config)
+			if err != nil {
+				t.Fatalf("NewJWTValidator: %v", err)
+			}
+
+			principal, err := validator.Validate(tt.token)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Validate accepted the token as %+v", principal)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if principal.ID != "seller-1" || principal.Name != "Seller One" {
+				t.Errorf("principal = %+v, want seller-1 named Seller One", principal)
+			}
+			if len(principal.Roles) != 1 || principal.Roles[0] != RoleSeller {
+				t.Errorf("roles = %v, want [%s]", principal.Roles, RoleSeller)
+			}
+		})
+	}
+}
+
+func TestJWTValidatorDefaultsToBuyer(t *testing.T) {
+	validator, err := NewJWTValidator(JWTConfig{HMACSecret: testHMACSecret})
+	if err != nil {
+		t.Fatalf("NewJWTValidator: %v", err)
+	}
+
+	token := signToken(t, jwt.SigningMethodHS256, []byte(testHMACSecret), "", withClaims(func(c jwt.MapClaims) { delete(c, "roles") }))
+	principal, err := validator.Validate(token)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if len(principal.Roles) != 1 || principal.Roles[0] != RoleBuyer {
+		t.Errorf("roles = %v, want [%s]", principal.Roles, RoleBuyer)
+	}
+}
+
+func TestNewJWTValidatorRequiresAKey(t *testing.T) {
+	if _, err := NewJWTValidator(JWTConfig{}); err == nil {
+		t.Fatal("NewJWTValidator accepted a configuration without keys")
+	}
+}
+
+func TestLoadJWKS(t *testing.T) {
+	key := generateRSAKey(t)
+	n := base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+
+	tests := []struct {
+		name     string
+		document string
+		wantKids []string
+		wantErr  bool
+	}{
+		{
+			name:     "RSA signing keys",
+			document: `{"keys":[{"kid":"a","kty":"RSA","use":"sig","n":"` + n + `","e":"AQAB"},{"kid":"b","kty":"RSA","n":"` + n + `","e":"AQAB"}]}`,
+			wantKids: []string{"a", "b"},
+		},
+		{
+			name:     "encryption and non-RSA keys are skipped",
+			document: `{"keys":[{"kid":"enc","kty":"RSA","use":"enc","n":"` + n + `","e":"AQAB"},{"kid":"ec","kty":"EC"},{"kid":"sig","kty":"RSA","n":"` + n + `","e":"AQAB"}]}`,
+			wantKids: []string{"sig"},
+		},
+		{
+			name:     "no usable key",
+			document: `{"keys":[{"kid":"ec","kty":"EC"}]}`,
+			wantErr:  true,
+		},
+		{
+			name:     "invalid modulus",
+			document: `{"keys":[{"kid":"a","kty":"RSA","n":"***","e":"AQAB"}]}`,
+			wantErr:  true,
+		},
+		{
+			name:     "invalid JSON",
+			document: `{"keys":`,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := loadJWKS(writeFile(t, "jwks.json", []byte(tt.document)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("loadJWKS returned %d keys, want an error", len(keys))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadJWKS: %v", err)
+			}
+
+			if len(keys) != len(tt.wantKids) {
+				t.Fatalf("loadJWKS returned %d keys, want %d", len(keys), len(tt.wantKids))
+			}
+			for _, kid := range tt.wantKids {
+				got, found := keys[kid]
+				if !found {
+					t.Fatalf("key %q missing", kid)
+				}
+				if got.N.Cmp(key.N) != 0 || got.E != key.E {
+					t.Errorf("key %q does not match the generated public key", kid)
+				}
+			}
+		})
+	}
+
+	if _, err := loadJWKS(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loadJWKS accepted a missing file")
+	}
+}
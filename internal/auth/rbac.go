@@ -0,0 +1,84 @@
+// internal/auth/rbac.go
+
+package auth
+
+// Role groups the permissions granted to a caller
+type Role string
+
+const (
+	RoleBuyer          Role = "buyer"
+	RoleSeller         Role = "seller"
+	RoleEtalaseManager Role = "etalase_manager"
+	RoleAdmin          Role = "admin"
+)
+
+// Permission names a single catalogue operation
+type Permission string
+
+const (
+	PermissionCreateProduct    Permission = "product:create"
+	PermissionUpdateOwnProduct Permission = "product:update:own"
+	PermissionUpdateAnyProduct Permission = "product:update:any"
+	PermissionMoveEtalase      Permission = "product:etalase:move"
+	PermissionCreateReview     Permission = "review:create"
+	PermissionVoteReview       Permission = "review:vote"
+	PermissionReplyReview      Permission = "review:reply"
+	PermissionModerateReview   Permission = "review:moderate"
+	PermissionManageAPIKeys    Permission = "apikey:manage"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleBuyer: {
+		PermissionCreateReview,
+		PermissionVoteReview,
+	},
+	RoleSeller: {
+		PermissionCreateProduct,
+		PermissionUpdateOwnProduct,
+		PermissionReplyReview,
+	},
+	RoleEtalaseManager: {
+		PermissionMoveEtalase,
+	},
+	RoleAdmin: {
+		PermissionCreateProduct,
+		PermissionUpdateOwnProduct,
+		PermissionUpdateAnyProduct,
+		PermissionMoveEtalase,
+		PermissionCreateReview,
+		PermissionVoteReview,
+		PermissionReplyReview,
+		PermissionModerateReview,
+		PermissionManageAPIKeys,
+	},
+}
+
+// IsKnownPermission reports whether the permission exists, admins being
+// granted every permission
+func IsKnownPermission(permission Permission) bool {
+	for _, granted := range rolePermissions[RoleAdmin] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the permission was granted directly or by
+// any of the principal's roles
+func (p *Principal) HasPermission(permission Permission) bool {
+	for _, granted := range p.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+
+	for _, role := range p.Roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
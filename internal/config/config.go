@@ -0,0 +1,458 @@
+// internal/config/config.go
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the service. Values come from the defaults,
+// then the optional YAML or TOML file named by CONFIG_FILE, then environment
+// variables.
+type Config struct {
+	Server      ServerConfig      `yaml:"server" toml:"server"`
+	Database    DatabaseConfig    `yaml:"database" toml:"database"`
+	Images      ImagesConfig      `yaml:"images" toml:"images"`
+	Auth        AuthConfig        `yaml:"auth" toml:"auth"`
+	Log         LogConfig         `yaml:"log" toml:"log"`
+	Tracing     TracingConfig     `yaml:"tracing" toml:"tracing"`
+	Idempotency IdempotencyConfig `yaml:"idempotency" toml:"idempotency"`
+}
+
+type ServerConfig struct {
+	ListenAddr    string `yaml:"listen_addr" toml:"listen_addr"`
+	PublicBaseURL string `yaml:"public_base_url" toml:"public_base_url"` // Used to build links returned to clients
+
+	// TrustForwardedHeaders derives links from X-Forwarded-Proto, -Host and
+	// -Prefix; enable it only behind a proxy that sets these headers
+	TrustForwardedHeaders bool `yaml:"trust_forwarded_headers" toml:"trust_forwarded_headers"`
+
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes" toml:"max_header_bytes"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"` // How long in-flight requests may drain on shutdown
+	ShutdownDelay     time.Duration `yaml:"shutdown_delay" toml:"shutdown_delay"`     // How long readiness fails before draining starts
+}
+
+type DatabaseConfig struct {
+	DSN             string        `yaml:"dsn" toml:"dsn"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	QueryTimeout    time.Duration `yaml:"query_timeout" toml:"query_timeout"` // Deadline of each repository call
+	AutoMigrate     bool          `yaml:"auto_migrate" toml:"auto_migrate"`   // Apply pending migrations on startup
+}
+
+type ImagesConfig struct {
+	Dir            string `yaml:"dir" toml:"dir"`
+	MaxBodyBytes   int64  `yaml:"max_body_bytes" toml:"max_body_bytes"`     // Largest accepted request body
+	MaxImageBytes  int64  `yaml:"max_image_bytes" toml:"max_image_bytes"`   // Largest accepted decoded image
+	MaxImages      int    `yaml:"max_images" toml:"max_images"`             // Most images per product or review
+	MaxImageWidth  int    `yaml:"max_image_width" toml:"max_image_width"`   // In pixels
+	MaxImageHeight int    `yaml:"max_image_height" toml:"max_image_height"` // In pixels
+
+	// MaxImagePixels caps width times height, guarding against decompression
+	// bombs. Decoding holds about 4 bytes per pixel, twice that when
+	// transcoding to JPEG, so the default allows 64 MB per image being decoded.
+	MaxImagePixels int64 `yaml:"max_image_pixels" toml:"max_image_pixels"`
+
+	// TranscodeFormat converts every uploaded image to "jpeg" or "png" when
+	// set; empty keeps the uploaded format
+	TranscodeFormat string `yaml:"transcode_format" toml:"transcode_format"`
+	JPEGQuality     int    `yaml:"jpeg_quality" toml:"jpeg_quality"` // 1 to 100, for transcoded JPEG images
+
+	// URLSigningKey enables HMAC-signed, expiring URLs for the images of
+	// unpublished products
+	URLSigningKey string        `yaml:"url_signing_key" toml:"url_signing_key"`
+	SignedURLTTL  time.Duration `yaml:"signed_url_ttl" toml:"signed_url_ttl"`
+}
+
+type AuthConfig struct {
+	JWTHS256Secret        string `yaml:"jwt_hs256_secret" toml:"jwt_hs256_secret"`
+	JWTRS256PublicKeyFile string `yaml:"jwt_rs256_public_key_file" toml:"jwt_rs256_public_key_file"`
+	JWTJWKSFile           string `yaml:"jwt_jwks_file" toml:"jwt_jwks_file"`
+	JWTIssuer             string `yaml:"jwt_issuer" toml:"jwt_issuer"`
+	JWTAudience           string `yaml:"jwt_audience" toml:"jwt_audience"`
+}
+
+type LogConfig struct {
+	Format string `yaml:"format" toml:"format"` // "json" or "text"
+	Level  string `yaml:"level" toml:"level"`   // debug, info, warn or error
+}
+
+type TracingConfig struct {
+	Exporter     string  `yaml:"exporter" toml:"exporter"`           // "none", "stdout" or "otlp"
+	OTLPEndpoint string  `yaml:"otlp_endpoint" toml:"otlp_endpoint"` // OTLP/HTTP collector URL; the OTEL_EXPORTER_OTLP_* variables apply when empty
+	ServiceName  string  `yaml:"service_name" toml:"service_name"`
+	SampleRatio  float64 `yaml:"sample_ratio" toml:"sample_ratio"` // Share of new traces recorded; sampled parents are always followed
+}
+
+type IdempotencyConfig struct {
+	TTL   time.Duration `yaml:"ttl" toml:"ttl"`     // How long responses to requests with an Idempotency-Key are replayed
+	Lease time.Duration `yaml:"lease" toml:"lease"` // How long a request in progress holds its key before a retry may take it over
+}
+
+// Default returns the configuration used when nothing else is set
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			ListenAddr:        ":8080",
+			PublicBaseURL:     "http://localhost:8080",
+			ReadTimeout:       30 * time.Second,
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			MaxHeaderBytes:    1 << 20, // 1 MiB
+			ShutdownTimeout:   30 * time.Second,
+		},
+		Database: DatabaseConfig{
+			DSN:             "host=localhost port=5432 dbname=product_catalog user=postgres password=postgres sslmode=disable",
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			QueryTimeout:    5 * time.Second,
+		},
+		Images: ImagesConfig{
+			Dir:            "internal/repositories/images",
+			MaxBodyBytes:   20 << 20, // 20 MiB
+			MaxImageBytes:  5 << 20,  // 5 MiB
+			MaxImages:      10,
+			MaxImageWidth:  8192,
+			MaxImageHeight: 8192,
+			MaxImagePixels: 16_000_000,
+			JPEGQuality:    85,
+			SignedURLTTL:   15 * time.Minute,
+		},
+		Log: LogConfig{
+			Format: "json",
+			Level:  "info",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "product-catalogue",
+			SampleRatio: 1,
+		},
+		Idempotency: IdempotencyConfig{
+			TTL:   24 * time.Hour,
+			Lease: 2 * time.Minute,
+		},
+	}
+}
+
+// Load builds the configuration and validates it
+func Load() (*Config, error) {
+	cfg := Default()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadFile reads the settings of a YAML or TOML file, picking the format by
+// the file extension
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = cfg.decodeYAML(data)
+	case ".toml":
+		err = cfg.decodeTOML(data)
+	default:
+		return fmt.Errorf("config file %s must have a .yaml, .yml or .toml extension", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// decodeYAML rejects unknown keys so that typos do not go unnoticed
+func (cfg *Config) decodeYAML(data []byte) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	return decoder.Decode(cfg)
+}
+
+// decodeTOML rejects unknown keys like decodeYAML
+func (cfg *Config) decodeTOML(data []byte) error {
+	metadata, err := toml.Decode(string(data), cfg)
+	if err != nil {
+		return err
+	}
+
+	if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, 0, len(undecoded))
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+		return fmt.Errorf("unknown keys %s", strings.Join(keys, ", "))
+	}
+
+	return nil
+}
+
+func (cfg *Config) loadEnv() error {
+	var errs []error
+
+	setString(&cfg.Server.ListenAddr, "LISTEN_ADDR")
+	setString(&cfg.Server.PublicBaseURL, "PUBLIC_BASE_URL")
+	errs = append(errs, setBool(&cfg.Server.TrustForwardedHeaders, "TRUST_FORWARDED_HEADERS"))
+	errs = append(errs, setDuration(&cfg.Server.ReadTimeout, "SERVER_READ_TIMEOUT"))
+	errs = append(errs, setDuration(&cfg.Server.ReadHeaderTimeout, "SERVER_READ_HEADER_TIMEOUT"))
+	errs = append(errs, setDuration(&cfg.Server.WriteTimeout, "SERVER_WRITE_TIMEOUT"))
+	errs = append(errs, setDuration(&cfg.Server.IdleTimeout, "SERVER_IDLE_TIMEOUT"))
+	errs = append(errs, setInt(&cfg.Server.MaxHeaderBytes, "SERVER_MAX_HEADER_BYTES"))
+	errs = append(errs, setDuration(&cfg.Server.ShutdownTimeout, "SERVER_SHUTDOWN_TIMEOUT"))
+	errs = append(errs, setDuration(&cfg.Server.ShutdownDelay, "SERVER_SHUTDOWN_DELAY"))
+
+	setString(&cfg.Database.DSN, "DB_DSN")
+	errs = append(errs, setInt(&cfg.Database.MaxOpenConns, "DB_MAX_OPEN_CONNS"))
+	errs = append(errs, setInt(&cfg.Database.MaxIdleConns, "DB_MAX_IDLE_CONNS"))
+	errs = append(errs, setDuration(&cfg.Database.ConnMaxLifetime, "DB_CONN_MAX_LIFETIME"))
+	errs = append(errs, setDuration(&cfg.Database.QueryTimeout, "DB_QUERY_TIMEOUT"))
+	errs = append(errs, setBool(&cfg.Database.AutoMigrate, "DB_AUTO_MIGRATE"))
+
+	setString(&cfg.Images.Dir, "IMAGE_DIR")
+	errs = append(errs, setInt64(&cfg.Images.MaxBodyBytes, "UPLOAD_MAX_BODY_BYTES"))
+	errs = append(errs, setInt64(&cfg.Images.MaxImageBytes, "UPLOAD_MAX_IMAGE_BYTES"))
+	errs = append(errs, setInt(&cfg.Images.MaxImages, "UPLOAD_MAX_IMAGES"))
+	errs = append(errs, setInt(&cfg.Images.MaxImageWidth, "UPLOAD_MAX_IMAGE_WIDTH"))
+	errs = append(errs, setInt(&cfg.Images.MaxImageHeight, "UPLOAD_MAX_IMAGE_HEIGHT"))
+	errs = append(errs, setInt64(&cfg.Images.MaxImagePixels, "UPLOAD_MAX_IMAGE_PIXELS"))
+	setString(&cfg.Images.TranscodeFormat, "IMAGE_TRANSCODE_FORMAT")
+	errs = append(errs, setInt(&cfg.Images.JPEGQuality, "IMAGE_JPEG_QUALITY"))
+	setString(&cfg.Images.URLSigningKey, "IMAGE_URL_SIGNING_KEY")
+	errs = append(errs, setDuration(&cfg.Images.SignedURLTTL, "IMAGE_URL_TTL"))
+
+	setString(&cfg.Auth.JWTHS256Secret, "JWT_HS256_SECRET")
+	setString(&cfg.Auth.JWTRS256PublicKeyFile, "JWT_RS256_PUBLIC_KEY_FILE")
+	setString(&cfg.Auth.JWTJWKSFile, "JWT_JWKS_FILE")
+	setString(&cfg.Auth.JWTIssuer, "JWT_ISSUER")
+	setString(&cfg.Auth.JWTAudience, "JWT_AUDIENCE")
+
+	setString(&cfg.Log.Format, "LOG_FORMAT")
+	setString(&cfg.Log.Level, "LOG_LEVEL")
+
+	setString(&cfg.Tracing.Exporter, "TRACING_EXPORTER")
+	setString(&cfg.Tracing.OTLPEndpoint, "TRACING_OTLP_ENDPOINT")
+	setString(&cfg.Tracing.ServiceName, "TRACING_SERVICE_NAME")
+	errs = append(errs, setFloat64(&cfg.Tracing.SampleRatio, "TRACING_SAMPLE_RATIO"))
+
+	errs = append(errs, setDuration(&cfg.Idempotency.TTL, "IDEMPOTENCY_TTL"))
+	errs = append(errs, setDuration(&cfg.Idempotency.Lease, "IDEMPOTENCY_LEASE"))
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once
+func (cfg *Config) Validate() error {
+	var errs []error
+
+	if cfg.Server.ListenAddr == "" {
+		errs = append(errs, errors.New("server.listen_addr is required"))
+	}
+
+	baseURL, err := url.Parse(cfg.Server.PublicBaseURL)
+	if err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		errs = append(errs, fmt.Errorf("server.public_base_url must be an absolute http(s) URL, got %q", cfg.Server.PublicBaseURL))
+	}
+	cfg.Server.PublicBaseURL = strings.TrimRight(cfg.Server.PublicBaseURL, "/")
+
+	if cfg.Server.ReadTimeout <= 0 || cfg.Server.ReadHeaderTimeout <= 0 || cfg.Server.WriteTimeout <= 0 || cfg.Server.IdleTimeout <= 0 {
+		errs = append(errs, errors.New("server read, read header, write and idle timeouts must be positive"))
+	}
+	if cfg.Server.MaxHeaderBytes <= 0 {
+		errs = append(errs, errors.New("server.max_header_bytes must be positive"))
+	}
+	if cfg.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server.shutdown_timeout must be positive"))
+	}
+	if cfg.Server.ShutdownDelay < 0 {
+		errs = append(errs, errors.New("server.shutdown_delay must not be negative"))
+	}
+
+	if cfg.Database.DSN == "" {
+		errs = append(errs, errors.New("database.dsn is required"))
+	}
+	if cfg.Database.MaxOpenConns <= 0 {
+		errs = append(errs, errors.New("database.max_open_conns must be positive"))
+	}
+	if cfg.Database.MaxIdleConns < 0 || cfg.Database.MaxIdleConns > cfg.Database.MaxOpenConns {
+		errs = append(errs, errors.New("database.max_idle_conns must be between 0 and max_open_conns"))
+	}
+	if cfg.Database.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("database.conn_max_lifetime must not be negative"))
+	}
+	if cfg.Database.QueryTimeout <= 0 {
+		errs = append(errs, errors.New("database.query_timeout must be positive"))
+	}
+
+	if cfg.Images.Dir == "" {
+		errs = append(errs, errors.New("images.dir is required"))
+	}
+	if cfg.Images.MaxBodyBytes <= 0 {
+		errs = append(errs, errors.New("images.max_body_bytes must be positive"))
+	}
+	if cfg.Images.MaxImageBytes <= 0 || cfg.Images.MaxImageBytes > cfg.Images.MaxBodyBytes {
+		errs = append(errs, errors.New("images.max_image_bytes must be positive and at most max_body_bytes"))
+	}
+	if cfg.Images.MaxImages < 0 {
+		errs = append(errs, errors.New("images.max_images must not be negative"))
+	}
+	if cfg.Images.MaxImageWidth <= 0 || cfg.Images.MaxImageHeight <= 0 || cfg.Images.MaxImagePixels <= 0 {
+		errs = append(errs, errors.New("images.max_image_width, max_image_height and max_image_pixels must be positive"))
+	}
+	if cfg.Images.TranscodeFormat != "" && cfg.Images.TranscodeFormat != "jpeg" && cfg.Images.TranscodeFormat != "png" {
+		errs = append(errs, fmt.Errorf("images.transcode_format must be empty, jpeg or png, got %q", cfg.Images.TranscodeFormat))
+	}
+	if cfg.Images.JPEGQuality < 1 || cfg.Images.JPEGQuality > 100 {
+		errs = append(errs, errors.New("images.jpeg_quality must be between 1 and 100"))
+	}
+
+	if cfg.Images.URLSigningKey != "" && len(cfg.Images.URLSigningKey) < 32 {
+		errs = append(errs, errors.New("images.url_signing_key must be at least 32 characters"))
+	}
+	if cfg.Images.SignedURLTTL <= 0 {
+		errs = append(errs, errors.New("images.signed_url_ttl must be positive"))
+	}
+
+	if cfg.Log.Format != "json" && cfg.Log.Format != "text" {
+		errs = append(errs, fmt.Errorf("log.format must be json or text, got %q", cfg.Log.Format))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("log.level must be debug, info, warn or error, got %q", cfg.Log.Level))
+	}
+
+	switch cfg.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter must be none, stdout or otlp, got %q", cfg.Tracing.Exporter))
+	}
+	if cfg.Tracing.ServiceName == "" {
+		errs = append(errs, errors.New("tracing.service_name is required"))
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing.sample_ratio must be between 0 and 1"))
+	}
+
+	if cfg.Idempotency.TTL <= 0 {
+		errs = append(errs, errors.New("idempotency.ttl must be positive"))
+	}
+	if cfg.Idempotency.Lease <= 0 || cfg.Idempotency.Lease > cfg.Idempotency.TTL {
+		errs = append(errs, errors.New("idempotency.lease must be positive and at most ttl"))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return nil
+}
+
+func setString(target *string, name string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*target = value
+	}
+}
+
+func setBool(target *bool, name string) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%s must be a boolean, got %q", name, value)
+	}
+	*target = parsed
+	return nil
+}
+
+func setInt(target *int, name string) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer, got %q", name, value)
+	}
+	*target = parsed
+	return nil
+}
+
+func setInt64(target *int64, name string) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer, got %q", name, value)
+	}
+	*target = parsed
+	return nil
+}
+
+func setFloat64(target *float64, name string) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("%s must be a number, got %q", name, value)
+	}
+	*target = parsed
+	return nil
+}
+
+func setDuration(target *time.Duration, name string) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%s must be a duration such as 30s, got %q", name, value)
+	}
+	*target = parsed
+	return nil
+}
@@ -0,0 +1,126 @@
+// internal/config/config_test.go
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes a config file in a temporary directory and returns its path
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+	return path
+}
+
+func TestExampleFilesMatchDefaults(t *testing.T) {
+	for _, path := range []string{"../../config.example.yaml", "../../config.example.toml"} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			cfg := &Config{}
+			if err := cfg.loadFile(path); err != nil {
+				t.Fatalf("loadFile: %v", err)
+			}
+			if !reflect.DeepEqual(cfg, Default()) {
+				t.Errorf("%s = %+v, want the defaults %+v", path, cfg, Default())
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "YAML",
+			file:    "config.yaml",
+			content: "server:\n  listen_addr: \":9090\"\nidempotency:\n  lease: 30s\n",
+		},
+		{
+			name:    "YAML with the short extension",
+			file:    "config.yml",
+			content: "server:\n  listen_addr: \":9090\"\nidempotency:\n  lease: 30s\n",
+		},
+		{
+			name:    "TOML",
+			file:    "config.toml",
+			content: "[server]\nlisten_addr = \":9090\"\n\n[idempotency]\nlease = \"30s\"\n",
+		},
+		{
+			name:    "unknown YAML key",
+			file:    "config.yaml",
+			content: "server:\n  listen_adr: \":9090\"\n",
+			wantErr: "listen_adr",
+		},
+		{
+			name:    "unknown TOML key",
+			file:    "config.toml",
+			content: "[server]\nlisten_adr = \":9090\"\n",
+			wantErr: "server.listen_adr",
+		},
+		{
+			name:    "invalid TOML",
+			file:    "config.toml",
+			content: "[server\n",
+			wantErr: "failed to parse config file",
+		},
+		{
+			name:    "unsupported extension",
+			file:    "config.json",
+			content: `{"server": {"listen_addr": ":9090"}}`,
+			wantErr: "must have a .yaml, .yml or .toml extension",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			err := cfg.loadFile(writeConfigFile(t, tt.file, tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadFile error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadFile: %v", err)
+			}
+
+			// Set keys are read and the others keep their defaults
+			if cfg.Server.ListenAddr != ":9090" || cfg.Idempotency.Lease != 30*time.Second {
+				t.Errorf("listen_addr = %q, lease = %v, want :9090 and 30s", cfg.Server.ListenAddr, cfg.Idempotency.Lease)
+			}
+			if cfg.Idempotency.TTL != Default().Idempotency.TTL || cfg.Server.PublicBaseURL != Default().Server.PublicBaseURL {
+				t.Errorf("unset keys lost their defaults: %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestLoadEnvironmentOverridesFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "config.toml", "[server]\nlisten_addr = \":9090\"\npublic_base_url = \"https://shop.example/\"\n"))
+	t.Setenv("LISTEN_ADDR", ":7070")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Server.ListenAddr != ":7070" {
+		t.Errorf("listen_addr = %q, want the environment's :7070", cfg.Server.ListenAddr)
+	}
+	if cfg.Server.PublicBaseURL != "https://shop.example" {
+		t.Errorf("public_base_url = %q, want the file's URL without the trailing slash", cfg.Server.PublicBaseURL)
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"product-catalogue-Telkom-LKPP/internal/auth"
+	"product-catalogue-Telkom-LKPP/internal/logging"
+	"product-catalogue-Telkom-LKPP/internal/models"
+	"product-catalogue-Telkom-LKPP/internal/problem"
+	"product-catalogue-Telkom-LKPP/internal/repositories"
+
+	"github.com/google/uuid"
+
+	"fmt"
+)
+
+type APIKeyHandler struct {
+	APIKeyRepo repositories.APIKeyRepository
+	SellerRepo repositories.SellerRepository
+}
+
+func NewAPIKeyHandler(apiKeyRepo repositories.APIKeyRepository, sellerRepo repositories.SellerRepository) *APIKeyHandler {
+	return &APIKeyHandler{
+		APIKeyRepo: apiKeyRepo,
+		SellerRepo: sellerRepo,
+	}
+}
+
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePermission(w, r, auth.PermissionManageAPIKeys); !ok {
+		return
+	}
+
+	// Parse JSON data from the request body
+	var requestBody models.APIKeyRequest
+
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	if fieldErrors := validateAPIKeyRequest(&requestBody); len(fieldErrors) > 0 {
+		problem.WriteFields(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Invalid API key", fieldErrors)
+		return
+	}
+
+	// Seller scoped keys must point at an existing seller
+	if requestBody.SellerID != "" {
+		_, err = h.SellerRepo.GetSellerByID(r.Context(), requestBody.SellerID)
+		if errors.Is(err, sql.ErrNoRows) {
+			problem.WriteFields(w, r, http.StatusNotFound, problem.CodeNotFound, "Seller not found", []models.FieldError{
+				{Field: "seller_id", Message: "seller does not exist"},
+			})
+			return
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to fetch seller", "seller_id", requestBody.SellerID, "error", err)
+			writeQueryError(w, r, err, "Failed to create API key")
+			return
+		}
+	}
+
+	key, hash, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to generate API key", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Failed to create API key")
+		return
+	}
+
+	apiKey := &models.APIKey{
+		ID:          uuid.New(),
+		Name:        requestBody.Name,
+		Prefix:      prefix,
+		Hash:        hash,
+		Permissions: requestBody.Permissions,
+		SellerID:    requestBody.SellerID,
+		ExpiresAt:   requestBody.ExpiresAt,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	err = h.APIKeyRepo.CreateAPIKey(r.Context(), apiKey)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to create API key", "api_key_id", apiKey.ID, "error", err)
+		writeQueryError(w, r, err, "Failed to create API key")
+		return
+	}
+
+	// The plaintext key is only ever returned here
+	response := struct {
+		Key    string         `json:"key"`
+		APIKey *models.APIKey `json:"api_key"`
+	}{
+		Key:    key,
+		APIKey: apiKey,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePermission(w, r, auth.PermissionManageAPIKeys); !ok {
+		return
+	}
+
+	apiKeys, err := h.APIKeyRepo.ListAPIKeys(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to list API keys", "error", err)
+		writeQueryError(w, r, err, "Failed to fetch API keys")
+		return
+	}
+
+	response := struct {
+		Data []*models.APIKey `json:"data"`
+	}{
+		Data: apiKeys,
+	}
+
+	// Return the response as JSON
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePermission(w, r, auth.PermissionManageAPIKeys); !ok {
+		return
+	}
+
+	// Extract key ID from the URL parameter
+	keyID, ok := parseUUIDParam(w, r, "keyID")
+	if !ok {
+		return
+	}
+
+	err := h.APIKeyRepo.RevokeAPIKey(r.Context(), keyID, time.Now().UTC())
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to revoke API key", "api_key_id", keyID, "error", err)
+		writeQueryError(w, r, err, "Failed to revoke API key")
+		return
+	}
+
+	// Respond with success message
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("API key revoked successfully"))
+}
+
+// validateAPIKeyRequest returns every field of the request that fails validation
+func validateAPIKeyRequest(request *models.APIKeyRequest) []models.FieldError {
+	var fieldErrors []models.FieldError
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		fieldErrors = append(fieldErrors, models.FieldError{Field: "name", Message: "is required"})
+	}
+
+	if len(request.Permissions) == 0 {
+		fieldErrors = append(fieldErrors, models.FieldError{Field: "permissions", Message: "must not be empty"})
+	}
+	for _, permission := range request.Permissions {
+		if !auth.IsKnownPermission(auth.Permission(permission)) {
+			fieldErrors = append(fieldErrors, models.FieldError{
+				Field:   "permissions",
+				Message: fmt.Sprintf("unknown permission %q", permission),
+			})
+		}
+	}
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		fieldErrors = append(fieldErrors, models.FieldError{Field: "expires_at", Message: "must be in the future"})
+	}
+
+	return fieldErrors
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+
+	"product-catalogue-Telkom-LKPP/internal/auth"
+	"product-catalogue-Telkom-LKPP/internal/models"
+	"product-catalogue-Telkom-LKPP/internal/problem"
+)
+
+// requirePermission returns the caller when it holds the permission, and
+// otherwise writes a 401 or 403 response and returns false
+func requirePermission(w http.ResponseWriter, r *http.Request, permission auth.Permission) (*auth.Principal, bool) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Authentication required")
+		return nil, false
+	}
+
+	if !principal.HasPermission(permission) {
+		writeForbidden(w, r, permission)
+		return nil, false
+	}
+
+	return principal, true
+}
+
+// writeForbidden responds with 403 naming the permission the caller lacks
+func writeForbidden(w http.ResponseWriter, r *http.Request, permission auth.Permission) {
+	p := problem.New(http.StatusForbidden, problem.CodeForbidden, "Missing permission "+string(permission))
+	p.MissingPermission = string(permission)
+	problem.WriteProblem(w, r, p)
+}
+
+// tenantScope returns the products the caller may see and edit: anonymous
+// callers only reach published products, sellers also their own, and callers
+// allowed to update any product reach the whole catalogue
+func tenantScope(r *http.Request) models.TenantScope {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		return models.TenantScope{}
+	}
+
+	return models.TenantScope{
+		SellerID:     principal.ID,
+		Unrestricted: principal.HasPermission(auth.PermissionUpdateAnyProduct),
+	}
+}
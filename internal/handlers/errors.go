@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+
+	"product-catalogue-Telkom-LKPP/internal/problem"
+	"product-catalogue-Telkom-LKPP/internal/repositories"
+)
+
+// statusClientClosedRequest is recorded when the client went away before the
+// response was ready; it never reaches the client
+const statusClientClosedRequest = 499
+
+// writeQueryError responds to a failed repository call with 504 when the
+// query ran past its deadline, and with 500 and the message otherwise; the
+// problem carries the request ID under which the cause was logged
+func writeQueryError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	switch {
+	case r.Context().Err() != nil:
+		w.WriteHeader(statusClientClosedRequest)
+	case repositories.IsTimeout(err):
+		problem.Write(w, r, http.StatusGatewayTimeout, problem.CodeQueryTimeout, "Database query timed out")
+	default:
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, message)
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"product-catalogue-Telkom-LKPP/internal/migrations"
+)
+
+// readinessCheckTimeout bounds each individual readiness check
+const readinessCheckTimeout = 2 * time.Second
+
+type HealthHandler struct {
+	DB       *sql.DB
+	Images   *ImageStore
+	Migrator *migrations.Migrator
+
+	shuttingDown atomic.Bool
+}
+
+func NewHealthHandler(db *sql.DB, images *ImageStore, migrator *migrations.Migrator) *HealthHandler {
+	return &HealthHandler{
+		DB:       db,
+		Images:   images,
+		Migrator: migrator,
+	}
+}
+
+// SetShuttingDown makes readiness fail so load balancers stop routing
+// traffic while in-flight requests drain
+func (h *HealthHandler) SetShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+type checkResult struct {
+	Status     string  `json:"status"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// Liveness reports that the process is up and able to serve requests
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// Readiness reports whether the service can handle traffic: the database
+// answers, image storage is writable and the schema is up to date
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func(ctx context.Context) error{
+		"database":      h.checkDatabase,
+		"image_storage": h.checkImageStorage,
+		"migrations":    h.checkMigrations,
+	}
+
+	response := healthResponse{
+		Status: "ok",
+		Checks: make(map[string]checkResult, len(checks)+1),
+	}
+
+	if h.shuttingDown.Load() {
+		response.Status = "unavailable"
+		response.Checks["shutdown"] = checkResult{Status: "failed", Error: "server is shutting down"}
+	}
+
+	for name, check := range checks {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+		start := time.Now()
+		err := check(ctx)
+		cancel()
+
+		result := checkResult{
+			Status:     "ok",
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if err != nil {
+			result.Status = "failed"
+			result.Error = err.Error()
+			response.Status = "unavailable"
+		}
+		response.Checks[name] = result
+	}
+
+	status := http.StatusOK
+	if response.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, response)
+}
+
+func (h *HealthHandler) checkDatabase(ctx context.Context) error {
+	return h.DB.PingContext(ctx)
+}
+
+func (h *HealthHandler) checkImageStorage(ctx context.Context) error {
+	file, err := os.CreateTemp(h.Images.Dir, ".readyz-*")
+	if err != nil {
+		return fmt.Errorf("image directory is not writable: %v", err)
+	}
+	file.Close()
+
+	return os.Remove(file.Name())
+}
+
+func (h *HealthHandler) checkMigrations(ctx context.Context) error {
+	pending, err := h.Migrator.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migrations, first is %04d_%s", len(pending), pending[0].Version, pending[0].Name)
+	}
+
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, status int, response healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,506 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // Register the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"product-catalogue-Telkom-LKPP/internal/config"
+	"product-catalogue-Telkom-LKPP/internal/logging"
+	"product-catalogue-Telkom-LKPP/internal/metrics"
+	"product-catalogue-Telkom-LKPP/internal/models"
+	"product-catalogue-Telkom-LKPP/internal/problem"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	_ "golang.org/x/image/webp" // Register the WebP decoder
+)
+
+var tracer = otel.Tracer("product-catalogue-Telkom-LKPP/internal/handlers")
+
+var (
+	errImageEncoding = errors.New("failed to decode base64 image")
+	errImageType     = errors.New("invalid image type")
+	errImageTooLarge = errors.New("image too large")
+	errTooManyImages = errors.New("too many images")
+)
+
+// imageError rejects the images of a request, or one of them
+type imageError struct {
+	field   string // "images", or "images[i]" for the image at index i
+	reason  error  // One of the errImage errors or errTooManyImages
+	message string // What is wrong with the images
+}
+
+func (e *imageError) Error() string {
+	return fmt.Sprintf("%s: %v: %s", e.field, e.reason, e.message)
+}
+
+func (e *imageError) Unwrap() error {
+	return e.reason
+}
+
+// imageExtensions maps the formats understood by image.DecodeConfig to the
+// extension the files are stored with. AVIF is accepted once a decoder for
+// it is registered with the image package.
+var imageExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+	"webp": ".webp",
+	"avif": ".avif",
+}
+
+// imageTypeNames names the image types in messages to clients
+var imageTypeNames = map[string]string{
+	".jpg":  "JPEG",
+	".png":  "PNG",
+	".gif":  "GIF",
+	".webp": "WebP",
+	".avif": "AVIF",
+}
+
+// imagePath is the route the images are served from
+const imagePath = "/products/images/"
+
+// ImageStore keeps the uploaded product and review images on local disk
+type ImageStore struct {
+	Dir                   string // Directory holding the image files
+	PublicBaseURL         string // Base URL of the service as seen by clients
+	TrustForwardedHeaders bool   // Derive the base URL from X-Forwarded-* headers
+	MaxImageBytes         int64  // Largest accepted decoded image
+	MaxImages             int    // Most images accepted per product or review
+	MaxImageWidth         int    // Widest accepted image, in pixels
+	MaxImageHeight        int    // Tallest accepted image, in pixels
+	MaxImagePixels        int64  // Largest accepted width times height
+	TranscodeFormat       string // Format every image is converted to ("jpeg" or "png"), if any
+	JPEGQuality           int    // Quality of transcoded JPEG images
+	SigningKey            []byte // Signs URLs of private images when set
+	SignedURLTTL          time.Duration
+}
+
+func NewImageStore(cfg *config.Config) *ImageStore {
+	store := &ImageStore{
+		Dir:                   cfg.Images.Dir,
+		PublicBaseURL:         cfg.Server.PublicBaseURL,
+		TrustForwardedHeaders: cfg.Server.TrustForwardedHeaders,
+		MaxImageBytes:         cfg.Images.MaxImageBytes,
+		MaxImages:             cfg.Images.MaxImages,
+		MaxImageWidth:         cfg.Images.MaxImageWidth,
+		MaxImageHeight:        cfg.Images.MaxImageHeight,
+		MaxImagePixels:        cfg.Images.MaxImagePixels,
+		TranscodeFormat:       cfg.Images.TranscodeFormat,
+		JPEGQuality:           cfg.Images.JPEGQuality,
+		SignedURLTTL:          cfg.Images.SignedURLTTL,
+	}
+	if cfg.Images.URLSigningKey != "" {
+		store.SigningKey = []byte(cfg.Images.URLSigningKey)
+	}
+
+	return store
+}
+
+// stagingDir is the subdirectory of the image directory holding images whose
+// product or review has not been saved yet
+const stagingDir = ".staging"
+
+// StagedImages are decoded images waiting in the staging directory. They are
+// moved in place by Publish once their product or review is about to be
+// saved, and removed by Discard unless Keep marked them as saved.
+type StagedImages struct {
+	Images []*models.ProductImage
+
+	stagedPaths []string // Staging path of each image, by index
+	published   int      // Number of images already moved in place
+	kept        bool
+}
+
+// Stage decodes the base64-encoded images, writes them to the staging
+// directory and returns their metadata, with the path they will be published at
+func (s *ImageStore) Stage(ctx context.Context, base64Images []string) (*StagedImages, error) {
+	staged := &StagedImages{}
+
+	if len(base64Images) > s.MaxImages {
+		return nil, &imageError{
+			field:   "images",
+			reason:  errTooManyImages,
+			message: fmt.Sprintf("must contain at most %d images", s.MaxImages),
+		}
+	}
+
+	if len(base64Images) > 0 {
+		if err := os.MkdirAll(filepath.Join(s.Dir, stagingDir), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create staging directory: %v", err)
+		}
+	}
+
+	for i, base64Image := range base64Images {
+		img, stagedPath, err := s.stage(ctx, i, base64Image)
+		if err != nil {
+			staged.Discard()
+			return nil, err
+		}
+
+		staged.Images = append(staged.Images, img)
+		staged.stagedPaths = append(staged.stagedPaths, stagedPath)
+	}
+
+	return staged, nil
+}
+
+func (s *ImageStore) stage(ctx context.Context, index int, base64Image string) (*models.ProductImage, string, error) {
+	reject := func(reason error, format string, args ...interface{}) (*models.ProductImage, string, error) {
+		return nil, "", &imageError{field: fmt.Sprintf("images[%d]", index), reason: reason, message: fmt.Sprintf(format, args...)}
+	}
+	tooLarge := fmt.Sprintf("must be at most %d bytes once decoded", s.MaxImageBytes)
+
+	// Reject oversized images before decoding them into memory
+	if int64(base64.StdEncoding.DecodedLen(len(base64Image))) > s.MaxImageBytes+2 {
+		return reject(errImageTooLarge, tooLarge)
+	}
+
+	imageData, err := base64.StdEncoding.DecodeString(base64Image)
+	if err != nil {
+		return reject(errImageEncoding, "must be base64-encoded")
+	}
+	if int64(len(imageData)) > s.MaxImageBytes {
+		return reject(errImageTooLarge, tooLarge)
+	}
+
+	// Detect the image type
+	ext := detectImageTypeByData(imageData)
+	if ext == "" {
+		return reject(errImageType, "must be a JPEG, PNG, GIF, WebP or AVIF image")
+	}
+	typeName := imageTypeNames[ext]
+
+	// Read the image header to make sure the content matches its type, and
+	// check the dimensions before anything decodes the pixels
+	imageConfig, format, err := image.DecodeConfig(bytes.NewReader(imageData))
+	if errors.Is(err, image.ErrFormat) {
+		return reject(errImageType, "%s images are not supported by this server", typeName)
+	}
+	if err != nil || imageExtensions[format] != ext {
+		return reject(errImageType, "is not a valid %s image", typeName)
+	}
+	if imageConfig.Width > s.MaxImageWidth || imageConfig.Height > s.MaxImageHeight || int64(imageConfig.Width)*int64(imageConfig.Height) > s.MaxImagePixels {
+		return reject(errImageTooLarge, "is %dx%d pixels; images must be at most %dx%d and %d pixels in total",
+			imageConfig.Width, imageConfig.Height, s.MaxImageWidth, s.MaxImageHeight, s.MaxImagePixels)
+	}
+
+	// Decode the whole image, so that truncated or corrupt files are rejected
+	decoded, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return reject(errImageType, "is not a valid %s image", typeName)
+	}
+
+	// Store every image in the same format when configured to
+	if s.TranscodeFormat != "" && imageExtensions[s.TranscodeFormat] != ext {
+		imageData, err = s.transcode(decoded)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to transcode image: %v", err)
+		}
+		ext = imageExtensions[s.TranscodeFormat]
+	}
+
+	// Generate UUID for the image
+	imgID := uuid.New()
+	fileName := fmt.Sprintf("%s%s", imgID.String(), ext)
+	stagedPath := filepath.Join(s.Dir, stagingDir, fileName)
+
+	// Store the image file in the staging directory
+	_, span := tracer.Start(ctx, "images.write", trace.WithAttributes(
+		attribute.String("image.file", fileName),
+		attribute.Int("image.bytes", len(imageData)),
+	))
+	err = os.WriteFile(stagedPath, imageData, 0644)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	if err != nil {
+		os.Remove(stagedPath)
+		return nil, "", fmt.Errorf("failed to store image: %v", err)
+	}
+	metrics.ImageBytesUploaded.Add(float64(len(imageData)))
+
+	return &models.ProductImage{
+		ID:       imgID,
+		FilePath: filepath.Join(s.Dir, fileName),
+		Type:     ext,
+		Width:    decoded.Bounds().Dx(),
+		Height:   decoded.Bounds().Dy(),
+	}, stagedPath, nil
+}
+
+// transcode encodes img in the configured format. Transparent areas become
+// white in JPEG images, and animated GIFs keep only their first frame.
+func (s *ImageStore) transcode(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch s.TranscodeFormat {
+	case "jpeg":
+		flattened := image.NewRGBA(img.Bounds())
+		draw.Draw(flattened, flattened.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(flattened, flattened.Bounds(), img, img.Bounds().Min, draw.Over)
+
+		if err := jpeg.Encode(&buf, flattened, &jpeg.Options{Quality: s.JPEGQuality}); err != nil {
+			return nil, err
+		}
+	case "png":
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported transcode format %q", s.TranscodeFormat)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Publish moves the staged images in place. Repositories call it right before
+// committing, so that a failure rolls the write back.
+func (staged *StagedImages) Publish() error {
+	for staged.published < len(staged.Images) {
+		i := staged.published
+		if err := os.Rename(staged.stagedPaths[i], staged.Images[i].FilePath); err != nil {
+			return fmt.Errorf("failed to publish image: %v", err)
+		}
+		staged.published++
+	}
+
+	return nil
+}
+
+// Keep marks the images as saved, so that Discard leaves them alone
+func (staged *StagedImages) Keep() {
+	staged.kept = true
+}
+
+// Discard removes the staged and published images unless they were kept
+func (staged *StagedImages) Discard() {
+	if staged.kept {
+		return
+	}
+
+	for i, img := range staged.Images {
+		path := staged.stagedPaths[i]
+		if i < staged.published {
+			path = img.FilePath
+		}
+		os.Remove(path)
+	}
+}
+
+// Remove deletes the files of images no longer referenced by any product or
+// review. Failures only leave files behind, so they are logged, not returned.
+func (s *ImageStore) Remove(ctx context.Context, images []*models.ProductImage) {
+	for _, img := range images {
+		path := filepath.Join(s.Dir, fmt.Sprintf("%s%s", img.ID, img.Type))
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logging.FromContext(ctx).Warn("failed to remove image", "image_id", img.ID, "error", err)
+		}
+	}
+}
+
+// PurgeStaged removes staged images older than maxAge, left behind when the
+// process stopped in the middle of a request, and returns how many it removed
+func (s *ImageStore) PurgeStaged(maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(s.Dir, stagingDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	cutoff := time.Now().Add(-maxAge)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.Dir, stagingDir, entry.Name())); err == nil {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+// writeImageError maps an error returned by ImageStore.Stage to a response
+// naming the rejected image
+func writeImageError(w http.ResponseWriter, r *http.Request, err error) {
+	var imgErr *imageError
+	if errors.As(err, &imgErr) {
+		status, code, detail := http.StatusBadRequest, problem.CodeInvalidImage, "Invalid image"
+		switch {
+		case errors.Is(err, errImageTooLarge):
+			status, code, detail = http.StatusRequestEntityTooLarge, problem.CodeImageTooLarge, "Image too large"
+		case errors.Is(err, errTooManyImages):
+			status, code, detail = http.StatusRequestEntityTooLarge, problem.CodeTooManyImages, "Too many images"
+		}
+
+		problem.WriteFields(w, r, status, code, detail, []models.FieldError{
+			{Field: imgErr.field, Message: imgErr.message},
+		})
+		return
+	}
+
+	logging.FromContext(r.Context()).Error("failed to store image", "error", err)
+	problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Failed to store image")
+}
+
+// SetURLs fills the URL of every image. Images of private products get a
+// signed, expiring URL when a signing key is configured.
+func (s *ImageStore) SetURLs(r *http.Request, images []*models.ProductImage, private bool) {
+	baseURL := s.baseURL(r) + imagePath
+	expires := time.Now().Add(s.SignedURLTTL).Unix()
+
+	for _, img := range images {
+		fileName := fmt.Sprintf("%s%s", img.ID, img.Type)
+		img.URL = baseURL + fileName
+
+		if private && s.SigningKey != nil {
+			query := url.Values{}
+			query.Set("expires", strconv.FormatInt(expires, 10))
+			query.Set("signature", s.signature(fileName, expires))
+			img.URL += "?" + query.Encode()
+		}
+	}
+}
+
+// VerifySignature reports whether the request carries a valid, unexpired
+// signature for the image file
+func (s *ImageStore) VerifySignature(r *http.Request, fileName string) bool {
+	if s.SigningKey == nil {
+		return false
+	}
+
+	query := r.URL.Query()
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	expected := s.signature(fileName, expires)
+	return hmac.Equal([]byte(expected), []byte(query.Get("signature")))
+}
+
+func (s *ImageStore) signature(fileName string, expires int64) string {
+	mac := hmac.New(sha256.New, s.SigningKey)
+	mac.Write([]byte(fileName + "|" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// baseURL returns the base URL clients reach the service under
+func (s *ImageStore) baseURL(r *http.Request) string {
+	if !s.TrustForwardedHeaders || r == nil {
+		return s.PublicBaseURL
+	}
+
+	host := firstForwardedValue(r.Header.Get("X-Forwarded-Host"))
+	if host == "" {
+		return s.PublicBaseURL
+	}
+
+	proto := firstForwardedValue(r.Header.Get("X-Forwarded-Proto"))
+	if proto != "https" {
+		proto = "http"
+	}
+
+	prefix := strings.TrimRight(firstForwardedValue(r.Header.Get("X-Forwarded-Prefix")), "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+
+	return proto + "://" + host + prefix
+}
+
+// firstForwardedValue returns the value set by the proxy closest to the client
+func firstForwardedValue(header string) string {
+	value, _, _ := strings.Cut(header, ",")
+	return strings.TrimSpace(value)
+}
+
+// writeDecodeError responds to a request body that could not be decoded
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		problem.Write(w, r, http.StatusRequestEntityTooLarge, problem.CodeRequestTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+		return
+	}
+
+	problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidJSON, "Failed to parse JSON data")
+}
+
+func detectImageTypeByData(data []byte) string {
+	// Define magic numbers for various image formats
+	jpegMagic := []byte{0xFF, 0xD8, 0xFF}
+	pngMagic := []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}
+	gif87Magic := []byte("GIF87a")
+	gif89Magic := []byte("GIF89a")
+
+	// Compare the first few bytes of data with magic numbers
+	if bytes.HasPrefix(data, jpegMagic) {
+		return ".jpg"
+	} else if bytes.HasPrefix(data, pngMagic) {
+		return ".png"
+	} else if bytes.HasPrefix(data, gif87Magic) || bytes.HasPrefix(data, gif89Magic) {
+		return ".gif"
+	} else if len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP" {
+		return ".webp"
+	} else if isAVIF(data) {
+		return ".avif"
+	}
+
+	return "" // Return empty string for unknown image types
+}
+
+// isAVIF reports whether data starts with an ISOBMFF "ftyp" box listing an
+// AVIF brand, as its major brand or among its compatible brands
+func isAVIF(data []byte) bool {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+
+	boxSize := int(binary.BigEndian.Uint32(data[0:4]))
+	if boxSize < 16 || boxSize > len(data) {
+		return false
+	}
+
+	// The major brand sits at offset 8 and the compatible brands from 16 on
+	for offset := 8; offset+4 <= boxSize; offset += 4 {
+		if offset == 12 {
+			continue // Minor version
+		}
+		if brand := string(data[offset : offset+4]); brand == "avif" || brand == "avis" {
+			return true
+		}
+	}
+
+	return false
+}
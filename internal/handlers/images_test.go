@@ -0,0 +1,615 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"product-catalogue-Telkom-LKPP/internal/models"
+	"product-catalogue-Telkom-LKPP/internal/problem"
+
+	"github.com/google/uuid"
+)
+
+const testSigningKey = "0123456789abcdef0123456789abcdef"
+
+func TestVerifySignature(t *testing.T) {
+	store := &ImageStore{
+		PublicBaseURL: "http://localhost:8080",
+		SigningKey:    []byte(testSigningKey),
+		SignedURLTTL:  time.Minute,
+	}
+	fileName := "0b6f4c8e-1f0c-4f5e-9a51-3f2d2b1c9e7a.png"
+	future := time.Now().Add(time.Minute).Unix()
+	past := time.Now().Add(-time.Minute).Unix()
+
+	signedQuery := func(expires int64, signature string) string {
+		query := url.Values{}
+		query.Set("expires", strconv.FormatInt(expires, 10))
+		query.Set("signature", signature)
+		return query.Encode()
+	}
+
+	tests := []struct {
+		name  string
+		store *ImageStore
+		file  string
+		query string
+		want  bool
+	}{
+		{
+			name:  "valid signature",
+			store: store,
+			file:  fileName,
+			query: signedQuery(future, store.signature(fileName, future)),
+			want:  true,
+		},
+		{
+			name:  "expired signature",
+			store: store,
+			file:  fileName,
+			query: signedQuery(past, store.signature(fileName, past)),
+		},
+		{
+			name:  "expiry extended after signing",
+			store: store,
+			file:  fileName,
+			query: signedQuery(future+3600, store.signature(fileName, future)),
+		},
+		{
+			name:  "signature of another file",
+			store: store,
+			file:  fileName,
+			query: signedQuery(future, store.signature("1c7a5d9f-2a1d-4b6f-8b62-4e3c3c2d0f8b.png", future)),
+		},
+		{
+			name:  "tampered signature",
+			store: store,
+			file:  fileName,
+			query: signedQuery(future, strings.Repeat("0", 64)),
+		},
+		{
+			name:  "missing expiry",
+			store: store,
+			file:  fileName,
+			query: "signature=" + store.signature(fileName, future),
+		},
+		{
+			name:  "no query",
+			store: store,
+			file:  fileName,
+		},
+		{
+			name:  "signing disabled",
+			store: &ImageStore{},
+			file:  fileName,
+			query: signedQuery(future, store.signature(fileName, future)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", imagePath+tt.file+"?"+tt.query, nil)
+			if got := tt.store.VerifySignature(r, tt.file); got != tt.want {
+				t.Errorf("VerifySignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetURLsSignsPrivateImages(t *testing.T) {
+	store := &ImageStore{
+		PublicBaseURL: "http://localhost:8080",
+		SigningKey:    []byte(testSigningKey),
+		SignedURLTTL:  time.Minute,
+	}
+	img := &models.ProductImage{ID: uuid.New(), Type: ".png"}
+	fileName := img.ID.String() + img.Type
+
+	store.SetURLs(nil, []*models.ProductImage{img}, false)
+	if want := "http://localhost:8080" + imagePath + fileName; img.URL != want {
+		t.Fatalf("public URL = %q, want %q", img.URL, want)
+	}
+
+	store.SetURLs(nil, []*models.ProductImage{img}, true)
+	r := httptest.NewRequest("GET", img.URL, nil)
+	if !store.VerifySignature(r, fileName) {
+		t.Fatalf("signed URL %q does not verify", img.URL)
+	}
+}
+
+func TestDetectImageTypeByData(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "JPEG", data: "\xFF\xD8\xFF\xE0\x00\x10JFIF", want: ".jpg"},
+		{name: "PNG", data: "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", want: ".png"},
+		{name: "PNG signature cut short", data: "\x89PNG"},
+		{name: "GIF87a", data: "GIF87a\x01\x00\x01\x00", want: ".gif"},
+		{name: "GIF89a", data: "GIF89a\x01\x00\x01\x00", want: ".gif"},
+		{name: "starts with GIF only", data: "GIF is a great format"},
+		{name: "WebP", data: "RIFF\x24\x00\x00\x00WEBPVP8 ", want: ".webp"},
+		{name: "RIFF but not WebP", data: "RIFF\x24\x00\x00\x00WAVEfmt "},
+		{name: "AVIF", data: "\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf", want: ".avif"},
+		{name: "HEIC", data: "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"},
+		{name: "text", data: "hello world"},
+		{name: "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectImageTypeByData([]byte(tt.data)); got != tt.want {
+				t.Errorf("detectImageTypeByData = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsAVIF(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{name: "avif major brand", data: "\x00\x00\x00\x14ftypavif\x00\x00\x00\x00mif1", want: true},
+		{name: "avis major brand", data: "\x00\x00\x00\x14ftypavis\x00\x00\x00\x00mif1", want: true},
+		{name: "avif compatible brand", data: "\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00miafavif", want: true},
+		{name: "avif as minor version only", data: "\x00\x00\x00\x14ftypmif1avifmiaf"},
+		{name: "avif brand past the box", data: "\x00\x00\x00\x14ftypmif1\x00\x00\x00\x00miafavif"},
+		{name: "box larger than the data", data: "\x00\x00\x01\x00ftypavif\x00\x00\x00\x00"},
+		{name: "box too small", data: "\x00\x00\x00\x08ftypavif\x00\x00\x00\x00"},
+		{name: "not an ftyp box", data: "\x00\x00\x00\x14moovavif\x00\x00\x00\x00mif1"},
+		{name: "too short", data: "\x00\x00\x00\x0cftyp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAVIF([]byte(tt.data)); got != tt.want {
+				t.Errorf("isAVIF = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestImageStore returns a store keeping its images in a temporary directory
+func newTestImageStore(t *testing.T) *ImageStore {
+	t.Helper()
+
+	return &ImageStore{
+		Dir:            t.TempDir(),
+		PublicBaseURL:  "http://localhost:8080",
+		MaxImageBytes:  1 << 20,
+		MaxImages:      3,
+		MaxImageWidth:  64,
+		MaxImageHeight: 64,
+		MaxImagePixels: 64 * 64,
+		JPEGQuality:    85,
+	}
+}
+
+// encodePNG returns a blank PNG image of the given size
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("encoding PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func pngBase64(t *testing.T, width, height int) string {
+	t.Helper()
+
+	return base64.StdEncoding.EncodeToString(encodePNG(t, width, height))
+}
+
+// listFiles returns the names of the regular files in dir, sorted
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("reading %s: %v", dir, err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// imageFileNames returns the file names the images are published under, sorted
+func imageFileNames(images []*models.ProductImage) []string {
+	var names []string
+	for _, img := range images {
+		names = append(names, filepath.Base(img.FilePath))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestStageWritesOnlyToStaging(t *testing.T) {
+	store := newTestImageStore(t)
+
+	staged, err := store.Stage(context.Background(), []string{pngBase64(t, 4, 4), pngBase64(t, 8, 2)})
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+
+	if files := listFiles(t, store.Dir); len(files) != 0 {
+		t.Errorf("public directory holds %v before publishing", files)
+	}
+	if files := listFiles(t, filepath.Join(store.Dir, stagingDir)); !equalStrings(files, imageFileNames(staged.Images)) {
+		t.Errorf("staging directory holds %v, want %v", files, imageFileNames(staged.Images))
+	}
+	if img := staged.Images[1]; img.Width != 8 || img.Height != 2 || img.Type != ".png" {
+		t.Errorf("second image = %dx%d %s, want 8x2 .png", img.Width, img.Height, img.Type)
+	}
+}
+
+func TestStagedImagesLifecycle(t *testing.T) {
+	tests := []struct {
+		name          string
+		publish       bool
+		keep          bool
+		wantPublished bool // Whether the images end up in the public directory
+	}{
+		{name: "transaction failed before publishing"},
+		{name: "commit failed after publishing", publish: true},
+		{name: "saved", publish: true, keep: true, wantPublished: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestImageStore(t)
+
+			staged, err := store.Stage(context.Background(), []string{pngBase64(t, 4, 4), pngBase64(t, 4, 4)})
+			if err != nil {
+				t.Fatalf("Stage: %v", err)
+			}
+
+			if tt.publish {
+				if err := staged.Publish(); err != nil {
+					t.Fatalf("Publish: %v", err)
+				}
+			}
+			if tt.keep {
+				staged.Keep()
+			}
+			staged.Discard()
+
+			var want []string
+			if tt.wantPublished {
+				want = imageFileNames(staged.Images)
+			}
+			if files := listFiles(t, store.Dir); !equalStrings(files, want) {
+				t.Errorf("public directory holds %v, want %v", files, want)
+			}
+			if files := listFiles(t, filepath.Join(store.Dir, stagingDir)); len(files) != 0 {
+				t.Errorf("staging directory still holds %v", files)
+			}
+		})
+	}
+}
+
+func TestStageDiscardsEarlierImagesOnFailure(t *testing.T) {
+	store := newTestImageStore(t)
+
+	_, err := store.Stage(context.Background(), []string{pngBase64(t, 4, 4), "not base64!"})
+	if err == nil {
+		t.Fatal("Stage accepted an invalid image")
+	}
+
+	if files := listFiles(t, filepath.Join(store.Dir, stagingDir)); len(files) != 0 {
+		t.Errorf("staging directory still holds %v", files)
+	}
+}
+
+func TestPurgeStaged(t *testing.T) {
+	store := newTestImageStore(t)
+	staging := filepath.Join(store.Dir, stagingDir)
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two files left behind by a crash, and one of a request still running
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"old-1.png", "old-2.jpg", "recent.png"} {
+		path := filepath.Join(staging, name)
+		if err := os.WriteFile(path, []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(name, "old") {
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	removed, err := store.PurgeStaged(time.Hour)
+	if err != nil {
+		t.Fatalf("PurgeStaged: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("PurgeStaged removed %d files, want 2", removed)
+	}
+	if files := listFiles(t, staging); !equalStrings(files, []string{"recent.png"}) {
+		t.Errorf("staging directory holds %v, want [recent.png]", files)
+	}
+}
+
+func TestPurgeStagedWithoutStagingDirectory(t *testing.T) {
+	store := newTestImageStore(t)
+
+	removed, err := store.PurgeStaged(time.Hour)
+	if err != nil || removed != 0 {
+		t.Errorf("PurgeStaged = %d, %v, want 0, nil", removed, err)
+	}
+}
+
+func TestRemoveDeletesOnlyGivenImages(t *testing.T) {
+	store := newTestImageStore(t)
+
+	kept := &models.ProductImage{ID: uuid.New(), Type: ".png"}
+	removed := &models.ProductImage{ID: uuid.New(), Type: ".png"}
+	missing := &models.ProductImage{ID: uuid.New(), Type: ".gif"}
+	for _, img := range []*models.ProductImage{kept, removed} {
+		if err := os.WriteFile(filepath.Join(store.Dir, img.ID.String()+img.Type), []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A file already gone is not an error
+	store.Remove(context.Background(), []*models.ProductImage{removed, missing})
+
+	if files := listFiles(t, store.Dir); !equalStrings(files, []string{kept.ID.String() + ".png"}) {
+		t.Errorf("image directory holds %v, want only %s.png", files, kept.ID)
+	}
+}
+
+// pngWithHeaderSize returns a 1x1 PNG whose header claims the given size,
+// as a decompression bomb would
+func pngWithHeaderSize(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+
+	data := encodePNG(t, 1, 1)
+
+	// The IHDR chunk follows the 8-byte signature: length, type, then width
+	// and height, and its CRC covers the type and data
+	binary.BigEndian.PutUint32(data[16:20], width)
+	binary.BigEndian.PutUint32(data[20:24], height)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestStageLimits(t *testing.T) {
+	encode := base64.StdEncoding.EncodeToString
+
+	tests := []struct {
+		name        string
+		configure   func(*ImageStore)
+		images      func(t *testing.T) []string
+		wantStatus  int // Zero when the images are accepted
+		wantCode    string
+		wantField   string
+		wantMessage string
+	}{
+		{
+			name: "at every limit",
+			images: func(t *testing.T) []string {
+				return []string{pngBase64(t, 64, 64), pngBase64(t, 1, 1), pngBase64(t, 1, 1)}
+			},
+		},
+		{
+			name: "too many images",
+			images: func(t *testing.T) []string {
+				return []string{pngBase64(t, 1, 1), pngBase64(t, 1, 1), pngBase64(t, 1, 1), pngBase64(t, 1, 1)}
+			},
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantCode:    problem.CodeTooManyImages,
+			wantField:   "images",
+			wantMessage: "must contain at most 3 images",
+		},
+		{
+			name:      "one byte over the size limit",
+			configure: func(s *ImageStore) { s.MaxImageBytes = 100 },
+			images: func(t *testing.T) []string {
+				return []string{pngBase64(t, 1, 1), encode(bytes.Repeat([]byte{0x89}, 101))}
+			},
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantCode:    problem.CodeImageTooLarge,
+			wantField:   "images[1]",
+			wantMessage: "must be at most 100 bytes once decoded",
+		},
+		{
+			name:      "far over the size limit",
+			configure: func(s *ImageStore) { s.MaxImageBytes = 100 },
+			images: func(t *testing.T) []string {
+				return []string{encode(bytes.Repeat([]byte{0x89}, 10000))}
+			},
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantCode:    problem.CodeImageTooLarge,
+			wantField:   "images[0]",
+			wantMessage: "must be at most 100 bytes once decoded",
+		},
+		{
+			name:        "too wide",
+			images:      func(t *testing.T) []string { return []string{pngBase64(t, 65, 1)} },
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantCode:    problem.CodeImageTooLarge,
+			wantField:   "images[0]",
+			wantMessage: "is 65x1 pixels; images must be at most 64x64 and 4096 pixels in total",
+		},
+		{
+			name:        "too tall",
+			images:      func(t *testing.T) []string { return []string{pngBase64(t, 1, 65)} },
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantCode:    problem.CodeImageTooLarge,
+			wantField:   "images[0]",
+			wantMessage: "is 1x65 pixels; images must be at most 64x64 and 4096 pixels in total",
+		},
+		{
+			name:        "too many pixels",
+			configure:   func(s *ImageStore) { s.MaxImagePixels = 100 },
+			images:      func(t *testing.T) []string { return []string{pngBase64(t, 11, 10)} },
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantCode:    problem.CodeImageTooLarge,
+			wantField:   "images[0]",
+			wantMessage: "is 11x10 pixels; images must be at most 64x64 and 100 pixels in total",
+		},
+		{
+			name:        "decompression bomb",
+			images:      func(t *testing.T) []string { return []string{encode(pngWithHeaderSize(t, 50000, 50000))} },
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantCode:    problem.CodeImageTooLarge,
+			wantField:   "images[0]",
+			wantMessage: "is 50000x50000 pixels; images must be at most 64x64 and 4096 pixels in total",
+		},
+		{
+			name:        "truncated",
+			images:      func(t *testing.T) []string { return []string{encode(encodePNG(t, 16, 16)[:60])} },
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    problem.CodeInvalidImage,
+			wantField:   "images[0]",
+			wantMessage: "is not a valid PNG image",
+		},
+		{
+			name: "corrupt pixel data",
+			images: func(t *testing.T) []string {
+				data := encodePNG(t, 16, 16)
+				data[len(data)-20] ^= 0xFF // Inside the IDAT chunk, breaking its checksum
+				return []string{encode(data)}
+			},
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    problem.CodeInvalidImage,
+			wantField:   "images[0]",
+			wantMessage: "is not a valid PNG image",
+		},
+		{
+			name:        "magic bytes only",
+			images:      func(t *testing.T) []string { return []string{encode([]byte("GIF89a"))} },
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    problem.CodeInvalidImage,
+			wantField:   "images[0]",
+			wantMessage: "is not a valid GIF image",
+		},
+		{
+			name:        "unknown type",
+			images:      func(t *testing.T) []string { return []string{encode([]byte("hello world"))} },
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    problem.CodeInvalidImage,
+			wantField:   "images[0]",
+			wantMessage: "must be a JPEG, PNG, GIF, WebP or AVIF image",
+		},
+		{
+			name:        "not base64",
+			images:      func(t *testing.T) []string { return []string{"not base64!"} },
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    problem.CodeInvalidImage,
+			wantField:   "images[0]",
+			wantMessage: "must be base64-encoded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestImageStore(t)
+			if tt.configure != nil {
+				tt.configure(store)
+			}
+
+			staged, err := store.Stage(context.Background(), tt.images(t))
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("Stage: %v", err)
+				}
+				staged.Discard()
+				return
+			}
+			if err == nil {
+				staged.Discard()
+				t.Fatal("Stage accepted the images")
+			}
+
+			rec := httptest.NewRecorder()
+			writeImageError(rec, httptest.NewRequest(http.MethodPost, "/products/", nil), err)
+
+			var p problem.Problem
+			if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+				t.Fatalf("decoding problem: %v", err)
+			}
+			if rec.Code != tt.wantStatus || p.Code != tt.wantCode {
+				t.Errorf("response = %d %s, want %d %s", rec.Code, p.Code, tt.wantStatus, tt.wantCode)
+			}
+			want := []models.FieldError{{Field: tt.wantField, Message: tt.wantMessage}}
+			if len(p.Errors) != 1 || p.Errors[0] != want[0] {
+				t.Errorf("errors = %+v, want %+v", p.Errors, want)
+			}
+
+			if files := listFiles(t, filepath.Join(store.Dir, stagingDir)); len(files) != 0 {
+				t.Errorf("staging directory still holds %v", files)
+			}
+		})
+	}
+}
+
+func TestWriteDecodeErrorBodyTooLarge(t *testing.T) {
+	body := http.MaxBytesReader(httptest.NewRecorder(), io.NopCloser(strings.NewReader(`{"title":"`+strings.Repeat("a", 100)+`"}`)), 64)
+	var request models.ProductRequest
+	err := json.NewDecoder(body).Decode(&request)
+
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		t.Fatalf("Decode error = %v, want a MaxBytesError", err)
+	}
+
+	rec := httptest.NewRecorder()
+	writeDecodeError(rec, httptest.NewRequest(http.MethodPost, "/products/", nil), err)
+
+	var p problem.Problem
+	if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+		t.Fatalf("decoding problem: %v", err)
+	}
+	if rec.Code != http.StatusRequestEntityTooLarge || p.Code != problem.CodeRequestTooLarge || p.Detail != "Request body exceeds 64 bytes" {
+		t.Errorf("response = %d %s %q, want 413 %s naming the limit", rec.Code, p.Code, p.Detail, problem.CodeRequestTooLarge)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
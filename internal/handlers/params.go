@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"product-catalogue-Telkom-LKPP/internal/models"
+	"product-catalogue-Telkom-LKPP/internal/problem"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// parseUUIDParam returns the named URL parameter as a UUID, and otherwise
+// writes a 400 response naming the parameter and returns false
+func parseUUIDParam(w http.ResponseWriter, r *http.Request, name string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, name))
+	if err != nil {
+		writeInvalidID(w, r, name)
+		return uuid.Nil, false
+	}
+
+	return id, true
+}
+
+// parseImageParam returns the UUID and extension of an image file name such
+// as 0b6f4c8e-1f0c-4f5e-9a51-3f2d2b1c9e7a.png, and otherwise writes a 400
+// response and returns false
+func parseImageParam(w http.ResponseWriter, r *http.Request, name string) (uuid.UUID, string, bool) {
+	fileName := chi.URLParam(r, name)
+	ext := filepath.Ext(fileName)
+	if ext == "" {
+		writeInvalidID(w, r, name)
+		return uuid.Nil, "", false
+	}
+
+	id, err := uuid.Parse(strings.TrimSuffix(fileName, ext))
+	if err != nil {
+		writeInvalidID(w, r, name)
+		return uuid.Nil, "", false
+	}
+
+	return id, ext, true
+}
+
+func writeInvalidID(w http.ResponseWriter, r *http.Request, name string) {
+	problem.WriteFields(w, r, http.StatusBadRequest, problem.CodeInvalidID, "Invalid "+name, []models.FieldError{
+		{Field: name, Message: "must be a valid UUID"},
+	})
+}
@@ -1,53 +1,83 @@
 package handlers
 
 import (
-	"bytes"
-	"encoding/base64"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"io"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
+	"product-catalogue-Telkom-LKPP/internal/auth"
+	"product-catalogue-Telkom-LKPP/internal/logging"
+	"product-catalogue-Telkom-LKPP/internal/metrics"
 	"product-catalogue-Telkom-LKPP/internal/models"
+	"product-catalogue-Telkom-LKPP/internal/problem"
 	"product-catalogue-Telkom-LKPP/internal/repositories"
+	"product-catalogue-Telkom-LKPP/internal/validation"
 
 	"github.com/go-chi/chi"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"fmt"
-	"io/ioutil"
 )
 
 type ProductHandler struct {
 	ProductRepo repositories.ProductRepository
+	SellerRepo  repositories.SellerRepository
+	Images      *ImageStore
 }
 
-func NewProductHandler(productRepo repositories.ProductRepository) *ProductHandler {
+func NewProductHandler(productRepo repositories.ProductRepository, sellerRepo repositories.SellerRepository, images *ImageStore) *ProductHandler {
 	return &ProductHandler{
 		ProductRepo: productRepo,
+		SellerRepo:  sellerRepo,
+		Images:      images,
 	}
 }
 
 func (h *ProductHandler) ServeImage(w http.ResponseWriter, r *http.Request) {
-	// Extract image ID from the URL parameter
-	imageID := chi.URLParam(r, "imageID")
+	// Extract image ID and file extension from the URL parameter
+	imgID, ext, ok := parseImageParam(w, r, "imageID")
+	if !ok {
+		return
+	}
+	imageID := imgID.String() + ext
 
-	// Check if the image ID includes a file extension
-	if filepath.Ext(imageID) == "" {
-		http.Error(w, "Invalid image ID format", http.StatusBadRequest)
+	// Images of unpublished products need a signed URL or access to the product
+	sellerID, published, err := h.ProductRepo.GetImageProduct(r.Context(), imgID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logging.FromContext(r.Context()).Error("failed to look up image product", "image_id", imgID, "error", err)
+		writeQueryError(w, r, err, "Failed to serve image")
 		return
 	}
+	if err == nil && !published {
+		scope := tenantScope(r)
+		canSee := scope.Unrestricted || (scope.SellerID != "" && scope.SellerID == sellerID)
+		if !canSee && !h.Images.VerifySignature(r, imageID) {
+			problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Image not found")
+			return
+		}
+		w.Header().Set("Cache-Control", "private")
+	}
 
 	// Construct the file path for the image
-	filePath := fmt.Sprintf("internal/repositories/images/%s", imageID)
+	filePath := filepath.Join(h.Images.Dir, imageID)
 
 	// Open the image file
+	_, span := tracer.Start(r.Context(), "images.read", trace.WithAttributes(attribute.String("image.file", imageID)))
+	defer span.End()
+
 	file, err := os.Open(filePath)
 	if err != nil {
-		http.Error(w, "Image not found", http.StatusNotFound)
+		span.RecordError(err)
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Image not found")
 		return
 	}
 	defer file.Close()
@@ -59,33 +89,69 @@ func (h *ProductHandler) ServeImage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Copy the image data to the response writer
-	_, err = io.Copy(w, file)
+	written, err := io.Copy(w, file)
+	metrics.ImageBytesServed.Add(float64(written))
+	span.SetAttributes(attribute.Int64("image.bytes", written))
 	if err != nil {
-		http.Error(w, "Failed to serve image", http.StatusInternalServerError)
+		span.RecordError(err)
+		logging.FromContext(r.Context()).Warn("failed to send image", "image_id", imgID, "error", err)
 	}
 }
 
 func (h *ProductHandler) GetProduct(w http.ResponseWriter, r *http.Request) {
 	// Extract product ID from the URL parameter
-	productID := chi.URLParam(r, "productID")
+	productID, ok := parseUUIDParam(w, r, "productID")
+	if !ok {
+		return
+	}
 
 	// Get the product details from the repository
-	product, err := h.ProductRepo.GetProductByID(productID)
+	product, err := h.ProductRepo.GetProductByID(r.Context(), productID, tenantScope(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Product not found")
+		return
+	}
 	if err != nil {
-		http.Error(w, "Product not found", http.StatusNotFound)
+		logging.FromContext(r.Context()).Error("failed to fetch product", "product_id", productID, "error", err)
+		writeQueryError(w, r, err, "Failed to fetch product")
 		return
 	}
 
-	// Convert product images to URLs
-	imageBaseURL := "http://localhost:8080/products/images/"
-	for _, img := range product.Images {
-		img.URL = fmt.Sprintf("%s%s%s", imageBaseURL, img.ID, img.Type)
+	h.writeProduct(w, r, product)
+}
+
+func (h *ProductHandler) GetProductBySKU(w http.ResponseWriter, r *http.Request) {
+	// Extract the SKU from the URL parameter and the optional seller filter
+	sku := chi.URLParam(r, "sku")
+	sellerID := r.URL.Query().Get("seller")
+
+	products, err := h.ProductRepo.GetProductsBySKU(r.Context(), sku, sellerID, tenantScope(r))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to fetch product by SKU", "sku", sku, "error", err)
+		writeQueryError(w, r, err, "Failed to fetch product")
+		return
 	}
 
+	// SKUs are only unique per seller
+	switch len(products) {
+	case 0:
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Product not found")
+	case 1:
+		h.writeProduct(w, r, products[0])
+	default:
+		problem.Write(w, r, http.StatusConflict, problem.CodeAmbiguousSKU, "Several sellers use this SKU; choose one with the seller query parameter")
+	}
+}
+
+// writeProduct responds with the product and the URLs of its images
+func (h *ProductHandler) writeProduct(w http.ResponseWriter, r *http.Request, product *models.Product) {
+	// Convert product images to URLs
+	h.Images.SetURLs(r, product.Images, !product.Published)
+
 	// Marshal the product data to JSON
 	productJSON, err := json.Marshal(product)
 	if err != nil {
-		http.Error(w, "Failed to marshal product data", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Failed to marshal product data")
 		return
 	}
 
@@ -101,6 +167,7 @@ func (h *ProductHandler) SearchProducts(w http.ResponseWriter, r *http.Request)
 	title := query.Get("title")
 	etalase := query.Get("etalase")
 	category := query.Get("category")
+	sellerID := query.Get("seller")
 	sortBy := query.Get("sortBy")
 	pageStr := query.Get("page")
 	perPageStr := query.Get("perPage")
@@ -121,16 +188,29 @@ func (h *ProductHandler) SearchProducts(w http.ResponseWriter, r *http.Request)
 		Title:    title,
 		Etalase:  etalase,
 		Category: category,
+		SellerID: sellerID,
 		SortBy:   sortBy,
 	}
 
 	// Get the list of products from the repository
-	products, err := h.ProductRepo.SearchProducts(productQuery, page, perPage)
+	products, err := h.ProductRepo.SearchProducts(r.Context(), productQuery, page, perPage, tenantScope(r))
+	if errors.Is(err, repositories.ErrNoSearchFilter) {
+		problem.WriteFields(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Invalid product query", []models.FieldError{
+			{Field: "query", Message: "set at least one of title, etalase, category or seller"},
+		})
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
-		fmt.Println(err)
+		logging.FromContext(r.Context()).Error("failed to search products", "error", err)
+		writeQueryError(w, r, err, "Failed to fetch products")
 		return
 	}
+	metrics.SearchResults.Observe(float64(len(products)))
+
+	// Convert product images to URLs
+	for _, product := range products {
+		h.Images.SetURLs(r, product.Images, !product.Published)
+	}
 
 	response := struct {
 		Data []*models.Product `json:"data"`
@@ -155,51 +235,52 @@ func (h *ProductHandler) SearchProducts(w http.ResponseWriter, r *http.Request)
 }
 
 func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePermission(w, r, auth.PermissionCreateProduct)
+	if !ok {
+		return
+	}
+
 	// Parse JSON data from the request body
 	var requestBody models.ProductRequest
 
 	err := json.NewDecoder(r.Body).Decode(&requestBody)
 	if err != nil {
-		http.Error(w, "Failed to parse JSON data", http.StatusBadRequest)
+		writeDecodeError(w, r, err)
 		return
 	}
 
-	// Process images
-	var images []*models.ProductImage
-
-	for _, base64Image := range requestBody.Images {
-		imageData, err := base64.StdEncoding.DecodeString(base64Image)
-		if err != nil {
-			http.Error(w, "Failed to decode base64 image", http.StatusInternalServerError)
-			return
-		}
-
-		// Detect the image type
-		ext := detectImageTypeByData(imageData)
-		if ext == "" {
-			http.Error(w, "Invalid image type", http.StatusBadRequest)
-			return
-		}
+	// Validate the product fields before touching the database
+	if fieldErrors := validation.ProductRequest(&requestBody); len(fieldErrors) > 0 {
+		problem.WriteFields(w, r, http.StatusUnprocessableEntity, problem.CodeValidationFailed, "Invalid product", fieldErrors)
+		return
+	}
 
-		// Generate UUID for the image
-		imgID := uuid.New()
+	// Products belong to a seller, which API keys not scoped to one do not name
+	if principal.IsUnscopedAPIKey() {
+		problem.Write(w, r, http.StatusForbidden, problem.CodeForbidden, "API keys not scoped to a seller cannot create products")
+		return
+	}
 
-		// Construct the file path for the image
-		filePath := filepath.Join("internal", "repositories", "images", fmt.Sprintf("%s%s", imgID.String(), ext))
+	// Register the seller owning the new product
+	err = h.SellerRepo.SaveSeller(r.Context(), &models.Seller{ID: principal.ID, Name: principal.Name})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to save seller", "seller_id", principal.ID, "error", err)
+		writeQueryError(w, r, err, "Failed to create product")
+		return
+	}
 
-		// Store the image file locally
-		err = ioutil.WriteFile(filePath, imageData, 0644)
-		if err != nil {
-			http.Error(w, "Failed to store image", http.StatusInternalServerError)
-			return
-		}
+	// Stage the images; they are published only if the product is saved
+	staged, err := h.Images.Stage(r.Context(), requestBody.Images)
+	if err != nil {
+		writeImageError(w, r, err)
+		return
+	}
+	defer staged.Discard()
 
-		// Create a ProductImage struct and append to the images slice
-		images = append(images, &models.ProductImage{
-			ID:       imgID,
-			FilePath: filePath,
-			Type:     ext,
-		})
+	// New products are published unless the seller asks otherwise
+	published := true
+	if requestBody.Published != nil {
+		published = *requestBody.Published
 	}
 
 	// Generate UUID for the product
@@ -213,17 +294,27 @@ func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 		Description: requestBody.Description,
 		Category:    requestBody.Category,
 		Etalase:     requestBody.Etalase,
-		Images:      images,
+		Images:      staged.Images,
 		Weight:      requestBody.Weight,
 		Price:       requestBody.Price,
+		Published:   published,
 	}
 
+	// Stamp the product with the seller creating it
+	product.SellerID = principal.ID
+
 	// Call the CreateProduct method of the repository to insert the product into the database
-	err = h.ProductRepo.CreateProduct(product)
+	err = h.ProductRepo.CreateProduct(r.Context(), product, staged.Publish)
+	if errors.Is(err, repositories.ErrDuplicateSKU) {
+		writeDuplicateSKU(w, r)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to create product", http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Error("failed to create product", "product_id", productID, "error", err)
+		writeQueryError(w, r, err, "Failed to create product")
 		return
 	}
+	staged.Keep()
 
 	// Respond with success message
 	w.WriteHeader(http.StatusCreated)
@@ -232,94 +323,161 @@ func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 
 func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	// Extract product ID from URL parameter
-	productID := chi.URLParam(r, "productID")
+	productID, ok := parseUUIDParam(w, r, "productID")
+	if !ok {
+		return
+	}
 
 	// Parse JSON data from the request body
 	var requestBody models.ProductRequest
 
 	err := json.NewDecoder(r.Body).Decode(&requestBody)
 	if err != nil {
-		http.Error(w, "Failed to parse JSON data", http.StatusBadRequest)
+		writeDecodeError(w, r, err)
 		return
 	}
 
-	// Process images
-	var images []*models.ProductImage
+	// Validate the product fields before touching the database
+	if fieldErrors := validation.ProductRequest(&requestBody); len(fieldErrors) > 0 {
+		problem.WriteFields(w, r, http.StatusUnprocessableEntity, problem.CodeValidationFailed, "Invalid product", fieldErrors)
+		return
+	}
+
+	// Load the current product to check ownership and etalase changes
+	scope := tenantScope(r)
+	existing, err := h.ProductRepo.GetProductByID(r.Context(), productID, scope)
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Product not found")
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to fetch product", "product_id", productID, "error", err)
+		writeQueryError(w, r, err, "Failed to update product")
+		return
+	}
 
-	for _, base64Image := range requestBody.Images {
-		imageData, err := base64.StdEncoding.DecodeString(base64Image)
-		if err != nil {
-			http.Error(w, "Failed to decode base64 image", http.StatusInternalServerError)
+	// Only the owning seller or an admin may update the product
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Authentication required")
+		return
+	}
+	if !principal.HasPermission(auth.PermissionUpdateAnyProduct) {
+		if existing.SellerID != principal.ID {
+			writeForbidden(w, r, auth.PermissionUpdateAnyProduct)
 			return
 		}
-
-		// Detect the image type
-		ext := detectImageTypeByData(imageData)
-		if ext == "" {
-			http.Error(w, "Invalid image type", http.StatusBadRequest)
+		if !principal.HasPermission(auth.PermissionUpdateOwnProduct) {
+			writeForbidden(w, r, auth.PermissionUpdateOwnProduct)
 			return
 		}
+	}
 
-		// Generate UUID for the image
-		imgID := uuid.New()
-
-		// Construct the file path for the image
-		filePath := filepath.Join("internal", "repositories", "images", fmt.Sprintf("%s%s", imgID.String(), ext))
+	// Moving the product to another etalase is reserved to etalase managers.
+	// The request was trimmed by validation, and older rows may not be.
+	if requestBody.Etalase != strings.TrimSpace(existing.Etalase) && !principal.HasPermission(auth.PermissionMoveEtalase) {
+		writeForbidden(w, r, auth.PermissionMoveEtalase)
+		return
+	}
 
-		// Store the image file locally
-		err = ioutil.WriteFile(filePath, imageData, 0644)
-		if err != nil {
-			http.Error(w, "Failed to store image", http.StatusInternalServerError)
-			return
-		}
+	// Stage the images; they are published only if the product is saved
+	staged, err := h.Images.Stage(r.Context(), requestBody.Images)
+	if err != nil {
+		writeImageError(w, r, err)
+		return
+	}
+	defer staged.Discard()
 
-		// Create a ProductImage struct and append to the images slice
-		images = append(images, &models.ProductImage{
-			ID:       imgID,
-			FilePath: filePath,
-			Type:     ext,
-		})
+	// Keep the publication state unless the request changes it
+	published := existing.Published
+	if requestBody.Published != nil {
+		published = *requestBody.Published
 	}
 
 	// Create a Product struct with the extracted data (similar to CreateProduct)
 	updatedProduct := &models.Product{
-		ID:          uuid.MustParse(productID),
+		ID:          productID,
 		SKU:         requestBody.SKU,
 		Title:       requestBody.Title,
 		Description: requestBody.Description,
 		Category:    requestBody.Category,
 		Etalase:     requestBody.Etalase,
-		Images:      images,
+		Images:      staged.Images,
 		Weight:      requestBody.Weight,
 		Price:       requestBody.Price,
+		Published:   published,
 	}
 
 	// Update the product in the repository (similar to CreateProduct)
-	err = h.ProductRepo.UpdateProduct(productID, updatedProduct)
+	replaced, err := h.ProductRepo.UpdateProduct(r.Context(), productID, updatedProduct, scope, staged.Publish)
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Product not found")
+		return
+	}
+	if errors.Is(err, repositories.ErrDuplicateSKU) {
+		writeDuplicateSKU(w, r)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to update product", http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Error("failed to update product", "product_id", productID, "error", err)
+		writeQueryError(w, r, err, "Failed to update product")
 		return
 	}
+	staged.Keep()
+
+	// The new images replace the previous ones
+	h.Images.Remove(r.Context(), replaced)
 
 	// Respond with success message
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Product updated successfully"))
 }
 
-func detectImageTypeByData(data []byte) string {
-	// Define magic numbers for various image formats
-	jpegMagic := []byte{0xFF, 0xD8, 0xFF}
-	pngMagic := []byte{0x89, 0x50, 0x4E, 0x47}
-	gifMagic := []byte("GIF")
+func (h *ProductHandler) MoveProductEtalase(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePermission(w, r, auth.PermissionMoveEtalase); !ok {
+		return
+	}
+
+	// Extract product ID from URL parameter
+	productID, ok := parseUUIDParam(w, r, "productID")
+	if !ok {
+		return
+	}
+
+	// Parse JSON data from the request body
+	var requestBody models.EtalaseRequest
 
-	// Compare the first few bytes of data with magic numbers
-	if bytes.HasPrefix(data, jpegMagic) {
-		return ".jpg"
-	} else if bytes.HasPrefix(data, pngMagic) {
-		return ".png"
-	} else if bytes.HasPrefix(data, gifMagic) {
-		return ".gif"
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
 	}
 
-	return "" // Return empty string for unknown image types
+	if fieldErrors := validation.EtalaseRequest(&requestBody); len(fieldErrors) > 0 {
+		problem.WriteFields(w, r, http.StatusUnprocessableEntity, problem.CodeValidationFailed, "Invalid etalase", fieldErrors)
+		return
+	}
+
+	// Etalase managers curate the whole catalogue, across sellers
+	err = h.ProductRepo.MoveProductEtalase(r.Context(), productID, requestBody.Etalase, models.TenantScope{Unrestricted: true})
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Product not found")
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to move product", "product_id", productID, "error", err)
+		writeQueryError(w, r, err, "Failed to move product")
+		return
+	}
+
+	// Respond with success message
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Product moved successfully"))
+}
+
+// writeDuplicateSKU responds with 409 to a SKU the seller already uses
+func writeDuplicateSKU(w http.ResponseWriter, r *http.Request) {
+	problem.WriteFields(w, r, http.StatusConflict, problem.CodeDuplicateSKU, repositories.ErrDuplicateSKU.Error(), []models.FieldError{
+		{Field: "sku", Message: "is already used by another of your products"},
+	})
 }
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"product-catalogue-Telkom-LKPP/internal/auth"
+	"product-catalogue-Telkom-LKPP/internal/models"
+	"product-catalogue-Telkom-LKPP/internal/repositories"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+var errInsertFailed = errors.New("insert failed")
+
+// fakeProductRepo runs the writes of a product like a transaction: failErr
+// fails it before the BeforeCommit hook runs, commitErr after
+type fakeProductRepo struct {
+	repositories.ProductRepository
+
+	existing  *models.Product
+	replaced  []*models.ProductImage
+	failErr   error
+	commitErr error
+	saved     *models.Product
+}
+
+func (repo *fakeProductRepo) GetProductByID(ctx context.Context, productID uuid.UUID, scope models.TenantScope) (*models.Product, error) {
+	return repo.existing, nil
+}
+
+func (repo *fakeProductRepo) CreateProduct(ctx context.Context, product *models.Product, beforeCommit repositories.BeforeCommit) error {
+	return repo.write(product, beforeCommit)
+}
+
+func (repo *fakeProductRepo) UpdateProduct(ctx context.Context, productID uuid.UUID, product *models.Product, scope models.TenantScope, beforeCommit repositories.BeforeCommit) ([]*models.ProductImage, error) {
+	if err := repo.write(product, beforeCommit); err != nil {
+		return nil, err
+	}
+	return repo.replaced, nil
+}
+
+func (repo *fakeProductRepo) write(product *models.Product, beforeCommit repositories.BeforeCommit) error {
+	if repo.failErr != nil {
+		return repo.failErr
+	}
+	if err := beforeCommit(); err != nil {
+		return err
+	}
+	if repo.commitErr != nil {
+		return repo.commitErr
+	}
+	repo.saved = product
+	return nil
+}
+
+type fakeSellerRepo struct {
+	repositories.SellerRepository
+}
+
+func (repo *fakeSellerRepo) SaveSeller(ctx context.Context, seller *models.Seller) error {
+	return nil
+}
+
+// newProductRequest returns a request to the product handler made by a seller
+func newProductRequest(t *testing.T, method, productID string, images []string) *http.Request {
+	t.Helper()
+
+	body, err := json.Marshal(models.ProductRequest{SKU: "KOPI-1", Title: "Kopi", Price: 75000, Images: images})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(method, "/products/", strings.NewReader(string(body)))
+	ctx := auth.NewContext(r.Context(), &auth.Principal{ID: "seller-1", Roles: []auth.Role{auth.RoleSeller}})
+	if productID != "" {
+		routeContext := chi.NewRouteContext()
+		routeContext.URLParams.Add("productID", productID)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, routeContext)
+	}
+	return r.WithContext(ctx)
+}
+
+func TestCreateProductImages(t *testing.T) {
+	tests := []struct {
+		name          string
+		repo          *fakeProductRepo
+		images        func(t *testing.T) []string
+		wantStatus    int
+		wantPublished bool
+	}{
+		{
+			name:          "saved",
+			repo:          &fakeProductRepo{},
+			wantStatus:    http.StatusCreated,
+			wantPublished: true,
+		},
+		{
+			name:       "insert failed before the hook ran",
+			repo:       &fakeProductRepo{failErr: errInsertFailed},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "commit failed after the hook ran",
+			repo:       &fakeProductRepo{commitErr: errInsertFailed},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "duplicate SKU",
+			repo:       &fakeProductRepo{failErr: repositories.ErrDuplicateSKU},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "later image truncated",
+			repo: &fakeProductRepo{},
+			images: func(t *testing.T) []string {
+				return []string{pngBase64(t, 4, 4), base64.StdEncoding.EncodeToString(encodePNG(t, 4, 4)[:60])}
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestImageStore(t)
+			h := NewProductHandler(tt.repo, &fakeSellerRepo{}, store)
+
+			images := []string{pngBase64(t, 4, 4), pngBase64(t, 2, 2)}
+			if tt.images != nil {
+				images = tt.images(t)
+			}
+
+			rec := httptest.NewRecorder()
+			h.CreateProduct(rec, newProductRequest(t, http.MethodPost, "", images))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			var want []string
+			if tt.wantPublished {
+				want = imageFileNames(tt.repo.saved.Images)
+			}
+			if files := listFiles(t, store.Dir); !equalStrings(files, want) {
+				t.Errorf("public directory holds %v, want %v", files, want)
+			}
+			if files := listFiles(t, filepath.Join(store.Dir, stagingDir)); len(files) != 0 {
+				t.Errorf("staging directory still holds %v", files)
+			}
+		})
+	}
+}
+
+func TestUpdateProductImages(t *testing.T) {
+	tests := []struct {
+		name       string
+		failErr    error
+		wantStatus int
+		wantSaved  bool
+	}{
+		{name: "saved", wantStatus: http.StatusOK, wantSaved: true},
+		{name: "update failed", failErr: errInsertFailed, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestImageStore(t)
+
+			// The product's current images, and an image of another product
+			current := []*models.ProductImage{
+				{ID: uuid.New(), Type: ".png"},
+				{ID: uuid.New(), Type: ".jpg"},
+			}
+			other := uuid.New().String() + ".png"
+			for _, name := range []string{current[0].ID.String() + ".png", current[1].ID.String() + ".jpg", other} {
+				if err := os.WriteFile(filepath.Join(store.Dir, name), []byte("image"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := listFiles(t, store.Dir)
+
+			productID := uuid.New()
+			repo := &fakeProductRepo{
+				existing: &models.Product{ID: productID, SellerID: "seller-1", Images: current, Published: true},
+				replaced: current,
+				failErr:  tt.failErr,
+			}
+			h := NewProductHandler(repo, &fakeSellerRepo{}, store)
+
+			rec := httptest.NewRecorder()
+			h.UpdateProduct(rec, newProductRequest(t, http.MethodPut, productID.String(), []string{pngBase64(t, 4, 4)}))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			// Only the replaced images give way to the new one
+			want := before
+			if tt.wantSaved {
+				want = imageFileNames(append([]*models.ProductImage{{FilePath: other}}, repo.saved.Images...))
+			}
+			if files := listFiles(t, store.Dir); !equalStrings(files, want) {
+				t.Errorf("public directory holds %v, want %v", files, want)
+			}
+			if files := listFiles(t, filepath.Join(store.Dir, stagingDir)); len(files) != 0 {
+				t.Errorf("staging directory still holds %v", files)
+			}
+		})
+	}
+}
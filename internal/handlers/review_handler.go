@@ -1,10 +1,20 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"product-catalogue-Telkom-LKPP/internal/auth"
+	"product-catalogue-Telkom-LKPP/internal/logging"
+	"product-catalogue-Telkom-LKPP/internal/metrics"
 	"product-catalogue-Telkom-LKPP/internal/models"
+	"product-catalogue-Telkom-LKPP/internal/problem"
 	"product-catalogue-Telkom-LKPP/internal/repositories"
 
 	"github.com/google/uuid"
@@ -12,46 +22,409 @@ import (
 	"fmt"
 )
 
+const (
+	minReviewRating        = 1
+	maxReviewRating        = 5
+	maxReviewCommentLength = 2000
+)
+
 type ReviewHandler struct {
-	ReviewRepo repositories.ReviewRepository
+	ReviewRepo       repositories.ReviewRepository
+	ProductRepo      repositories.ProductRepository
+	PurchaseVerifier repositories.PurchaseVerifier
+	Images           *ImageStore
 }
 
-func NewReviewHandler(reviewRepo repositories.ReviewRepository) *ReviewHandler {
+func NewReviewHandler(reviewRepo repositories.ReviewRepository, productRepo repositories.ProductRepository, purchaseVerifier repositories.PurchaseVerifier, images *ImageStore) *ReviewHandler {
 	return &ReviewHandler{
-		ReviewRepo: reviewRepo,
+		ReviewRepo:       reviewRepo,
+		ProductRepo:      productRepo,
+		PurchaseVerifier: purchaseVerifier,
+		Images:           images,
 	}
 }
 
 func (h *ReviewHandler) CreateReview(w http.ResponseWriter, r *http.Request) {
+	// Reviews are always attributed to the authenticated caller
+	principal, ok := requirePermission(w, r, auth.PermissionCreateReview)
+	if !ok {
+		return
+	}
+
 	// Parse JSON data from the request body
-	var requestBody models.Review
+	var requestBody models.ReviewRequest
 
 	err := json.NewDecoder(r.Body).Decode(&requestBody)
 	if err != nil {
-		http.Error(w, "Failed to parse JSON data", http.StatusBadRequest)
+		writeDecodeError(w, r, err)
 		return
 	}
 
+	// Validate the review fields before touching the database
+	if fieldErrors := validateReview(&requestBody); len(fieldErrors) > 0 {
+		problem.WriteFields(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Invalid review", fieldErrors)
+		return
+	}
+
+	// Make sure the reviewed product exists
+	_, err = h.ProductRepo.GetProductByID(r.Context(), requestBody.ProductID, tenantScope(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.WriteFields(w, r, http.StatusNotFound, problem.CodeNotFound, "Product not found", []models.FieldError{
+			{Field: "product_id", Message: "product does not exist"},
+		})
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to fetch reviewed product", "product_id", requestBody.ProductID, "error", err)
+		writeQueryError(w, r, err, "Failed to create review")
+		return
+	}
+
+	// Reject a second review of the same product by the same reviewer
+	reviewed, err := h.ReviewRepo.HasReviewed(r.Context(), requestBody.ProductID, principal.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to check for an existing review", "product_id", requestBody.ProductID, "error", err)
+		writeQueryError(w, r, err, "Failed to create review")
+		return
+	}
+	if reviewed {
+		problem.Write(w, r, http.StatusConflict, problem.CodeDuplicateReview, repositories.ErrDuplicateReview.Error())
+		return
+	}
+
+	// Mark the review as verified when the reviewer bought the product; a
+	// failing verifier only costs the badge, not the review itself
+	verified, err := h.PurchaseVerifier.HasPurchased(r.Context(), principal.ID, requestBody.ProductID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to verify purchase", "product_id", requestBody.ProductID, "error", err)
+		verified = false
+	}
+
+	// Stage the images, stored alongside product images once the review is saved
+	staged, err := h.Images.Stage(r.Context(), requestBody.Images)
+	if err != nil {
+		writeImageError(w, r, err)
+		return
+	}
+	defer staged.Discard()
+
 	// Generate UUID for the review
 	reviewID := uuid.New()
 
 	// Create a Review struct with the extracted data
 	review := &models.Review{
-		ID:        reviewID,
-		ProductID: requestBody.ProductID,
-		Rating:    requestBody.Rating,
-		Comment:   requestBody.Comment,
+		ID:           reviewID,
+		ProductID:    requestBody.ProductID,
+		ReviewerID:   principal.ID,
+		ReviewerName: principal.Name,
+		Rating:       requestBody.Rating,
+		Comment:      requestBody.Comment,
+		Images:       staged.Images,
+		Verified:     verified,
+		CreatedAt:    time.Now().UTC(),
 	}
 
 	// Call the CreateReview method of the repository to insert the review into the database
-	err = h.ReviewRepo.CreateReview(review)
+	err = h.ReviewRepo.CreateReview(r.Context(), review, staged.Publish)
+	if errors.Is(err, repositories.ErrDuplicateReview) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeDuplicateReview, err.Error())
+		return
+	}
 	if err != nil {
-		fmt.Println(err)
-		http.Error(w, "Failed to create review", http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Error("failed to create review", "review_id", reviewID, "error", err)
+		writeQueryError(w, r, err, "Failed to create review")
 		return
 	}
+	staged.Keep()
+	metrics.ReviewsCreated.WithLabelValues(strconv.FormatBool(verified)).Inc()
 
 	// Respond with success message
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte(fmt.Sprintf("Review created successfully %s", reviewID)))
 }
+
+func (h *ReviewHandler) ListReviews(w http.ResponseWriter, r *http.Request) {
+	// Parse query parameters
+	query := r.URL.Query()
+	productIDStr := query.Get("product_id")
+	sortBy := query.Get("sortBy")
+	pageStr := query.Get("page")
+	perPageStr := query.Get("perPage")
+
+	productID, err := uuid.Parse(productIDStr)
+	if err != nil {
+		problem.WriteFields(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Invalid review query", []models.FieldError{
+			{Field: "product_id", Message: "must be a valid UUID"},
+		})
+		return
+	}
+
+	// Reviews of a product are only listed to those who may see the product
+	_, err = h.ProductRepo.GetProductByID(r.Context(), productID, tenantScope(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Product not found")
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to fetch reviewed product", "product_id", productID, "error", err)
+		writeQueryError(w, r, err, "Failed to fetch reviews")
+		return
+	}
+
+	// Convert page and perPage parameters to integers with default values
+	page, err := strconv.Atoi(pageStr)
+	if err != nil || page < 0 {
+		page = 0
+	}
+
+	perPage, err := strconv.Atoi(perPageStr)
+	if err != nil || perPage <= 0 {
+		perPage = 10
+	}
+
+	reviewQuery := &models.ReviewQuery{
+		ProductID: productID,
+		SortBy:    sortBy,
+	}
+
+	// Get the list of reviews from the repository
+	reviews, err := h.ReviewRepo.ListReviews(r.Context(), reviewQuery, page, perPage)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to list reviews", "product_id", productID, "error", err)
+		writeQueryError(w, r, err, "Failed to fetch reviews")
+		return
+	}
+
+	// Convert review images to URLs
+	for _, review := range reviews {
+		h.Images.SetURLs(r, review.Images, false)
+	}
+
+	response := struct {
+		Data []*models.Review `json:"data"`
+		Meta struct {
+			Page  int `json:"page"`
+			Limit int `json:"limit"`
+		} `json:"meta"`
+	}{
+		Data: reviews,
+		Meta: struct {
+			Page  int `json:"page"`
+			Limit int `json:"limit"`
+		}{
+			Page:  page + 1,
+			Limit: perPage,
+		},
+	}
+
+	// Return the response as JSON
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *ReviewHandler) VoteReview(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePermission(w, r, auth.PermissionVoteReview)
+	if !ok {
+		return
+	}
+
+	// Extract review ID from the URL parameter
+	reviewID, ok := parseUUIDParam(w, r, "reviewID")
+	if !ok {
+		return
+	}
+
+	// Parse JSON data from the request body
+	var requestBody models.ReviewVoteRequest
+
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	if requestBody.Helpful == nil {
+		problem.WriteFields(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Invalid vote", []models.FieldError{
+			{Field: "helpful", Message: "is required"},
+		})
+		return
+	}
+
+	review, err := h.ReviewRepo.GetReviewByID(r.Context(), reviewID)
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Review not found")
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to fetch review", "review_id", reviewID, "error", err)
+		writeQueryError(w, r, err, "Failed to record vote")
+		return
+	}
+
+	// Reviews of products the caller cannot see are reported as missing
+	_, err = h.ProductRepo.GetProductByID(r.Context(), review.ProductID, tenantScope(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Review not found")
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to fetch reviewed product", "product_id", review.ProductID, "error", err)
+		writeQueryError(w, r, err, "Failed to record vote")
+		return
+	}
+
+	// Reviewers cannot vote on their own review
+	if review.ReviewerID == principal.ID {
+		problem.Write(w, r, http.StatusForbidden, problem.CodeForbidden, "Cannot vote on your own review")
+		return
+	}
+
+	vote := &models.ReviewVote{
+		ReviewID: reviewID,
+		VoterID:  principal.ID,
+		Helpful:  *requestBody.Helpful,
+	}
+
+	err = h.ReviewRepo.VoteReview(r.Context(), vote)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to record vote", "review_id", reviewID, "error", err)
+		writeQueryError(w, r, err, "Failed to record vote")
+		return
+	}
+
+	// Respond with success message
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Vote recorded successfully"))
+}
+
+func (h *ReviewHandler) ReplyReview(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePermission(w, r, auth.PermissionReplyReview)
+	if !ok {
+		return
+	}
+
+	// Extract review ID from the URL parameter
+	reviewID, ok := parseUUIDParam(w, r, "reviewID")
+	if !ok {
+		return
+	}
+
+	// Parse JSON data from the request body
+	var requestBody models.ReviewReplyRequest
+
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	requestBody.Comment = strings.TrimSpace(requestBody.Comment)
+	if requestBody.Comment == "" || utf8.RuneCountInString(requestBody.Comment) > maxReviewCommentLength {
+		problem.WriteFields(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Invalid reply", []models.FieldError{
+			{Field: "comment", Message: fmt.Sprintf("must be between 1 and %d characters", maxReviewCommentLength)},
+		})
+		return
+	}
+
+	review, err := h.ReviewRepo.GetReviewByID(r.Context(), reviewID)
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Review not found")
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to fetch review", "review_id", reviewID, "error", err)
+		writeQueryError(w, r, err, "Failed to create reply")
+		return
+	}
+
+	// Only the owner of the reviewed product may reply; an unpublished
+	// product is only found in its owner's scope
+	product, err := h.ProductRepo.GetProductByID(r.Context(), review.ProductID, tenantScope(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Write(w, r, http.StatusForbidden, problem.CodeForbidden, "Only the product owner can reply to this review")
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to fetch reviewed product", "product_id", review.ProductID, "error", err)
+		writeQueryError(w, r, err, "Failed to create reply")
+		return
+	}
+	if product.SellerID == "" || product.SellerID != principal.ID {
+		problem.Write(w, r, http.StatusForbidden, problem.CodeForbidden, "Only the product owner can reply to this review")
+		return
+	}
+
+	reply := &models.ReviewReply{
+		ReviewID:  reviewID,
+		SellerID:  principal.ID,
+		Comment:   requestBody.Comment,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	err = h.ReviewRepo.ReplyReview(r.Context(), reply)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to create reply", "review_id", reviewID, "error", err)
+		writeQueryError(w, r, err, "Failed to create reply")
+		return
+	}
+
+	// Respond with success message
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("Reply created successfully"))
+}
+
+func (h *ReviewHandler) ModerateReview(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePermission(w, r, auth.PermissionModerateReview); !ok {
+		return
+	}
+
+	// Extract review ID from the URL parameter
+	reviewID, ok := parseUUIDParam(w, r, "reviewID")
+	if !ok {
+		return
+	}
+
+	// Remove the review together with its votes and reply
+	images, err := h.ReviewRepo.DeleteReview(r.Context(), reviewID)
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Review not found")
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to delete review", "review_id", reviewID, "error", err)
+		writeQueryError(w, r, err, "Failed to delete review")
+		return
+	}
+
+	// Moderated photos must not stay reachable
+	h.Images.Remove(r.Context(), images)
+
+	// Respond with success message
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Review deleted successfully"))
+}
+
+// validateReview returns every field of the review that fails validation
+func validateReview(review *models.ReviewRequest) []models.FieldError {
+	var fieldErrors []models.FieldError
+
+	if review.ProductID == uuid.Nil {
+		fieldErrors = append(fieldErrors, models.FieldError{Field: "product_id", Message: "is required"})
+	}
+
+	if review.Rating < minReviewRating || review.Rating > maxReviewRating {
+		fieldErrors = append(fieldErrors, models.FieldError{
+			Field:   "rating",
+			Message: fmt.Sprintf("must be between %d and %d", minReviewRating, maxReviewRating),
+		})
+	}
+
+	if utf8.RuneCountInString(review.Comment) > maxReviewCommentLength {
+		fieldErrors = append(fieldErrors, models.FieldError{
+			Field:   "comment",
+			Message: fmt.Sprintf("must be at most %d characters", maxReviewCommentLength),
+		})
+	}
+
+	return fieldErrors
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"product-catalogue-Telkom-LKPP/internal/logging"
+	"product-catalogue-Telkom-LKPP/internal/problem"
+	"product-catalogue-Telkom-LKPP/internal/repositories"
+
+	"github.com/go-chi/chi"
+)
+
+type SellerHandler struct {
+	SellerRepo repositories.SellerRepository
+}
+
+func NewSellerHandler(sellerRepo repositories.SellerRepository) *SellerHandler {
+	return &SellerHandler{
+		SellerRepo: sellerRepo,
+	}
+}
+
+func (h *SellerHandler) GetSeller(w http.ResponseWriter, r *http.Request) {
+	// Extract seller ID from the URL parameter
+	sellerID := chi.URLParam(r, "sellerID")
+
+	// Get the seller details from the repository
+	seller, err := h.SellerRepo.GetSellerByID(r.Context(), sellerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Seller not found")
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to fetch seller", "seller_id", sellerID, "error", err)
+		writeQueryError(w, r, err, "Failed to fetch seller")
+		return
+	}
+
+	// Return the response as JSON
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(seller)
+}
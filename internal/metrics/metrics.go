@@ -0,0 +1,79 @@
+// internal/metrics/metrics.go
+
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "product_catalogue"
+
+var (
+	// HTTPRequests counts served requests by chi route pattern, never by raw
+	// path, so that IDs in URLs do not explode the number of series
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time spent serving HTTP requests, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	ImageBytesUploaded = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_uploaded_bytes_total",
+		Help:      "Decoded bytes of product and review images stored.",
+	})
+
+	ImageBytesServed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_served_bytes_total",
+		Help:      "Bytes of images sent to clients.",
+	})
+
+	SearchResults = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "product_search_results",
+		Help:      "Number of products returned per search.",
+		Buckets:   []float64{0, 1, 5, 10, 25, 50, 100},
+	})
+
+	ReviewsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reviews_created_total",
+		Help:      "Reviews created, by whether the purchase was verified.",
+	}, []string{"verified"})
+)
+
+// NewRegistry returns a registry holding the catalogue metrics, the
+// connection pool statistics of db and the Go runtime and process metrics
+func NewRegistry(db *sql.DB) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, "product_catalog"),
+		HTTPRequests,
+		HTTPRequestDuration,
+		ImageBytesUploaded,
+		ImageBytesServed,
+		SearchResults,
+		ReviewsCreated,
+	)
+	return registry
+}
+
+// Handler serves the metrics of the registry in the Prometheus text format
+func Handler(registry *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
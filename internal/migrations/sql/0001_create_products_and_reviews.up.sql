@@ -0,0 +1,25 @@
+-- Baseline schema. IF NOT EXISTS lets databases created from the schema
+-- formerly documented in the README adopt the migrations.
+CREATE TABLE IF NOT EXISTS products (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    sku VARCHAR(50) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    description TEXT,
+    category VARCHAR(50),
+    etalase VARCHAR(50),
+    images JSONB, -- Store image metadata as JSONB
+    weight DECIMAL(10, 2),
+    price DECIMAL(10, 2),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The documented schema was missing the DEFAULT keyword on created_at
+ALTER TABLE products ADD COLUMN IF NOT EXISTS created_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE products ALTER COLUMN created_at SET DEFAULT CURRENT_TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS product_reviews (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID REFERENCES products(id),
+    rating INT CHECK (rating >= 1 AND rating <= 5),
+    review_comment TEXT
+);
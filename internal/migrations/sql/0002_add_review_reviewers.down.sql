@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS orders;
+DROP INDEX IF EXISTS product_reviews_product_reviewer_idx;
+
+ALTER TABLE product_reviews
+    DROP COLUMN IF EXISTS created_at,
+    DROP COLUMN IF EXISTS verified,
+    DROP COLUMN IF EXISTS images,
+    DROP COLUMN IF EXISTS reviewer_name,
+    DROP COLUMN IF EXISTS reviewer_id;
@@ -0,0 +1,21 @@
+-- Reviews written before reviewer identity existed keep a NULL reviewer
+ALTER TABLE product_reviews
+    ADD COLUMN IF NOT EXISTS reviewer_id VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS reviewer_name VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS images JSONB, -- Store review photo metadata as JSONB
+    ADD COLUMN IF NOT EXISTS verified BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS product_reviews_product_reviewer_idx
+    ON product_reviews (product_id, reviewer_id);
+
+-- Orders mark reviews as verified purchases
+CREATE TABLE IF NOT EXISTS orders (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    buyer_id VARCHAR(255) NOT NULL,
+    product_id UUID REFERENCES products(id),
+    status VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS orders_buyer_product_idx ON orders (buyer_id, product_id);
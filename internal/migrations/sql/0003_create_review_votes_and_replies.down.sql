@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS review_replies;
+DROP TABLE IF EXISTS review_votes;
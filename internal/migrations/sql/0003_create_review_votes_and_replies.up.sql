@@ -0,0 +1,16 @@
+-- One helpfulness vote per user and review
+CREATE TABLE IF NOT EXISTS review_votes (
+    review_id UUID REFERENCES product_reviews(id),
+    voter_id VARCHAR(255) NOT NULL,
+    helpful BOOLEAN NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (review_id, voter_id)
+);
+
+-- Public seller answer to a review
+CREATE TABLE IF NOT EXISTS review_replies (
+    review_id UUID PRIMARY KEY REFERENCES product_reviews(id),
+    seller_id VARCHAR(255) NOT NULL,
+    reply_comment TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS products_images_idx;
+DROP INDEX IF EXISTS products_seller_idx;
+
+ALTER TABLE products
+    DROP COLUMN IF EXISTS published,
+    DROP COLUMN IF EXISTS seller_id;
+
+DROP TABLE IF EXISTS sellers;
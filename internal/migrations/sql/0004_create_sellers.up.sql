@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS sellers (
+    id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS seller_id VARCHAR(255) REFERENCES sellers(id),
+    ADD COLUMN IF NOT EXISTS published BOOLEAN NOT NULL DEFAULT TRUE;
+
+CREATE INDEX IF NOT EXISTS products_seller_idx ON products (seller_id);
+
+-- Look up the product owning an image
+CREATE INDEX IF NOT EXISTS products_images_idx ON products USING GIN (images jsonb_path_ops);
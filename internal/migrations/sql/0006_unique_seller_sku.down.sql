@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS products_sku_idx;
+DROP INDEX IF EXISTS products_seller_sku_key;
@@ -0,0 +1,7 @@
+-- SKUs are unique per seller. Products sharing a SKU must be renamed first;
+-- list them with:
+--   SELECT seller_id, sku, COUNT(*) FROM products GROUP BY seller_id, sku HAVING COUNT(*) > 1;
+CREATE UNIQUE INDEX IF NOT EXISTS products_seller_sku_key ON products (seller_id, sku);
+
+-- Look up products by SKU across sellers
+CREATE INDEX IF NOT EXISTS products_sku_idx ON products (sku);
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey authenticates a machine-to-machine integration. Only the SHA-256
+// hash of the key is stored; the plaintext key is shown once on creation.
+type APIKey struct {
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"` // First characters of the key, to recognise it
+	Hash        string     `json:"-"`
+	Permissions []string   `json:"permissions"`
+	SellerID    string     `json:"seller_id,omitempty"` // Seller the key acts for, if any
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+type APIKeyRequest struct {
+	Name        string     `json:"name"`
+	Permissions []string   `json:"permissions"`
+	SellerID    string     `json:"seller_id"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// IdempotencyRecord remembers the response to a request sent with an
+// Idempotency-Key header, so that a retry is answered without redoing it
+type IdempotencyRecord struct {
+	PrincipalID string
+	Key         string
+	RequestHash string // SHA-256 of the method, path and body
+	StatusCode  int    // Zero while the original request is in progress
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
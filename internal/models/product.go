@@ -6,6 +6,7 @@ import (
 
 type Product struct {
 	ID          uuid.UUID       `json:"id"`
+	SellerID    string          `json:"seller_id"` // Owner of the product
 	SKU         string          `json:"sku"`
 	Title       string          `json:"title"`
 	Description string          `json:"description"`
@@ -15,6 +16,7 @@ type Product struct {
 	Weight      float64         `json:"weight"`
 	Price       float64         `json:"price"`
 	Rating      float64         `json:"rating"`
+	Published   bool            `json:"published"` // Unpublished products are only visible to their seller
 }
 
 type ProductImage struct {
@@ -23,6 +25,8 @@ type ProductImage struct {
 	Description string    `json:"description"`
 	URL         string    `json:"url"`
 	Type        string    `json:"type"`
+	Width       int       `json:"width,omitempty"` // In pixels; unknown for images uploaded before it was recorded
+	Height      int       `json:"height,omitempty"`
 }
 
 type ProductQuery struct {
@@ -30,6 +34,7 @@ type ProductQuery struct {
 	Etalase  string `json:"etalase"`
 	Category string `json:"category"`
 	SKU      string `json:"sku"`
+	SellerID string `json:"seller_id"`
 	SortBy   string `json:"sortBy"`
 }
 
@@ -42,4 +47,9 @@ type ProductRequest struct {
 	Weight      float64  `json:"weight"`
 	Price       float64  `json:"price"`
 	Images      []string `json:"images"` // Base64-encoded image strings
+	Published   *bool    `json:"published"`
+}
+
+type EtalaseRequest struct {
+	Etalase string `json:"etalase"`
 }
@@ -1,12 +1,64 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
 type Review struct {
-	ID        uuid.UUID `json:"id"`
+	ID           uuid.UUID       `json:"id"`
+	ProductID    uuid.UUID       `json:"product_id"`
+	ReviewerID   string          `json:"reviewer_id"`
+	ReviewerName string          `json:"reviewer_name"`
+	Rating       int             `json:"rating"`
+	Comment      string          `json:"comment"`
+	Images       []*ProductImage `json:"images"`
+	Verified     bool            `json:"verified"` // Reviewer purchased the product
+	CreatedAt    time.Time       `json:"created_at"`
+
+	HelpfulCount    int          `json:"helpful_count"`
+	NotHelpfulCount int          `json:"not_helpful_count"`
+	Reply           *ReviewReply `json:"reply,omitempty"`
+}
+
+// ReviewReply is the public answer of the product owner to a review
+type ReviewReply struct {
+	ReviewID  uuid.UUID `json:"review_id"`
+	SellerID  string    `json:"seller_id"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReviewVote records whether a user found a review helpful
+type ReviewVote struct {
+	ReviewID uuid.UUID `json:"review_id"`
+	VoterID  string    `json:"voter_id"`
+	Helpful  bool      `json:"helpful"`
+}
+
+type ReviewRequest struct {
 	ProductID uuid.UUID `json:"product_id"`
 	Rating    int       `json:"rating"`
 	Comment   string    `json:"comment"`
+	Images    []string  `json:"images"` // Base64-encoded image strings
+}
+
+type ReviewQuery struct {
+	ProductID uuid.UUID `json:"product_id"`
+	SortBy    string    `json:"sortBy"`
+}
+
+type ReviewVoteRequest struct {
+	Helpful *bool `json:"helpful"`
+}
+
+type ReviewReplyRequest struct {
+	Comment string `json:"comment"`
+}
+
+// FieldError describes a single invalid field in a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// Seller is the vendor owning a set of products in the catalogue
+type Seller struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TenantScope restricts repository access to the products a caller may see
+// and edit. Published products are visible to everyone, unpublished products
+// only to their seller, and an unrestricted scope reaches every product.
+type TenantScope struct {
+	SellerID     string
+	Unrestricted bool
+}
@@ -0,0 +1,89 @@
+// internal/problem/problem.go
+
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"product-catalogue-Telkom-LKPP/internal/logging"
+	"product-catalogue-Telkom-LKPP/internal/models"
+)
+
+// ContentType is the media type of RFC 7807 problem details
+const ContentType = "application/problem+json"
+
+// typePrefix turns an error code into the problem type URI
+const typePrefix = "urn:product-catalogue:problem:"
+
+// Error codes are part of the API: clients may branch on them, so existing
+// codes must never change meaning
+const (
+	CodeInvalidJSON           = "invalid_json"
+	CodeRequestTooLarge       = "request_too_large"
+	CodeValidationFailed      = "validation_failed"
+	CodeInvalidID             = "invalid_id"
+	CodeInvalidIdempotencyKey = "invalid_idempotency_key"
+	CodeInvalidImage          = "invalid_image"
+	CodeTooManyImages         = "too_many_images"
+	CodeImageTooLarge         = "image_too_large"
+	CodeUnauthorized          = "unauthorized"
+	CodeForbidden             = "forbidden"
+	CodeNotFound              = "not_found"
+	CodeMethodNotAllowed      = "method_not_allowed"
+	CodeDuplicateReview       = "duplicate_review"
+	CodeDuplicateSKU          = "duplicate_sku"
+	CodeAmbiguousSKU          = "ambiguous_sku"
+	CodeIdempotencyKeyInUse   = "idempotency_key_in_use"
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+	CodeQueryTimeout          = "query_timeout"
+	CodeInternal              = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details object extended with a stable error
+// code, the request ID to quote when reporting the error, and the invalid fields
+type Problem struct {
+	Type              string              `json:"type"`
+	Title             string              `json:"title"`
+	Status            int                 `json:"status"`
+	Detail            string              `json:"detail,omitempty"`
+	Instance          string              `json:"instance,omitempty"`
+	Code              string              `json:"code"`
+	RequestID         string              `json:"request_id,omitempty"`
+	Errors            []models.FieldError `json:"errors,omitempty"`
+	MissingPermission string              `json:"missing_permission,omitempty"`
+}
+
+// New returns the problem for status with the given code and detail
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   typePrefix + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Write responds with a problem built from status, code and detail
+func Write(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	WriteProblem(w, r, New(status, code, detail))
+}
+
+// WriteFields responds with a problem listing the fields that failed validation
+func WriteFields(w http.ResponseWriter, r *http.Request, status int, code, detail string, fieldErrors []models.FieldError) {
+	p := New(status, code, detail)
+	p.Errors = fieldErrors
+	WriteProblem(w, r, p)
+}
+
+// WriteProblem responds with p, filling in the request path and ID
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	p.Instance = r.URL.Path
+	p.RequestID = logging.RequestID(r.Context())
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,154 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"product-catalogue-Telkom-LKPP/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, apiKey *models.APIKey) error
+	ListAPIKeys(ctx context.Context) ([]*models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error)
+	TouchAPIKey(ctx context.Context, keyID uuid.UUID, usedAt time.Time) error
+	RevokeAPIKey(ctx context.Context, keyID uuid.UUID, revokedAt time.Time) error
+}
+
+type apiKeyRepository struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+func NewAPIKeyRepository(db *sql.DB, queryTimeout time.Duration) APIKeyRepository {
+	return &apiKeyRepository{
+		DB:           db,
+		QueryTimeout: queryTimeout,
+	}
+}
+
+const apiKeyColumns = `
+	id, name, key_prefix, key_hash, permissions, COALESCE(seller_id, ''),
+	expires_at, last_used_at, created_at, revoked_at
+`
+
+type apiKeyScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row apiKeyScanner) (*models.APIKey, error) {
+	var apiKey models.APIKey
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&apiKey.ID,
+		&apiKey.Name,
+		&apiKey.Prefix,
+		&apiKey.Hash,
+		pq.Array(&apiKey.Permissions),
+		&apiKey.SellerID,
+		&expiresAt,
+		&lastUsedAt,
+		&apiKey.CreatedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey.ExpiresAt = nullTimePtr(expiresAt)
+	apiKey.LastUsedAt = nullTimePtr(lastUsedAt)
+	apiKey.RevokedAt = nullTimePtr(revokedAt)
+
+	return &apiKey, nil
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func (repo *apiKeyRepository) CreateAPIKey(ctx context.Context, apiKey *models.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, `
+		INSERT INTO api_keys (id, name, key_prefix, key_hash, permissions, seller_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8)
+	`, apiKey.ID, apiKey.Name, apiKey.Prefix, apiKey.Hash, pq.Array(apiKey.Permissions), apiKey.SellerID, apiKey.ExpiresAt, apiKey.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert API key: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *apiKeyRepository) ListAPIKeys(ctx context.Context) ([]*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, `SELECT`+apiKeyColumns+`FROM api_keys ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apiKeys := []*models.APIKey{}
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	return apiKeys, rows.Err()
+}
+
+func (repo *apiKeyRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	return scanAPIKey(repo.DB.QueryRowContext(ctx, `SELECT`+apiKeyColumns+`FROM api_keys WHERE key_hash = $1`, hash))
+}
+
+func (repo *apiKeyRepository) TouchAPIKey(ctx context.Context, keyID uuid.UUID, usedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $1 WHERE id = $2`, usedAt, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to update API key last use: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *apiKeyRepository) RevokeAPIKey(ctx context.Context, keyID uuid.UUID, revokedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	result, err := repo.DB.ExecContext(ctx, `
+		UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL
+	`, revokedAt, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
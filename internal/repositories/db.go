@@ -1,30 +1,62 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"product-catalogue-Telkom-LKPP/internal/config"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
-func NewDBConnection() (*sql.DB, error) {
-	host := "localhost"
-	port := 5432
-	dbname := "product_catalog"
-	user := "postgres"
-	password := "postgres"
-
-	connStr := fmt.Sprintf("host=%s port=%d dbname=%s user=%s password=%s sslmode=disable",
-		host, port, dbname, user, password)
-
-	db, err := sql.Open("postgres", connStr)
+func NewDBConnection(cfg config.DatabaseConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.DSN)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to the database: %v", err)
 	}
 
-	// Set connection pool settings (optional)
-	db.SetMaxOpenConns(10) // Maximum number of open connections
-	db.SetMaxIdleConns(5)  // Maximum number of idle connections
+	// Set connection pool settings
+	db.SetMaxOpenConns(cfg.MaxOpenConns)       // Maximum number of open connections
+	db.SetMaxIdleConns(cfg.MaxIdleConns)       // Maximum number of idle connections
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime) // Maximum lifetime of a connection
 
 	return db, nil
 }
+
+// IsTimeout reports whether err comes from a query that ran past its
+// deadline, whether database/sql or PostgreSQL noticed it first
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014" // query_canceled
+}
+
+// isUniqueViolation reports whether err was raised by the named unique index
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
+
+// BeforeCommit runs inside a write transaction right before it commits, so
+// that a side effect such as publishing image files rolls the write back
+// when it fails
+type BeforeCommit func() error
+
+// commit runs beforeCommit, when set, then commits tx
+func commit(tx *sql.Tx, beforeCommit BeforeCommit) error {
+	if beforeCommit != nil {
+		if err := beforeCommit(); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
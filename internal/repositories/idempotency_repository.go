@@ -0,0 +1,137 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"product-catalogue-Telkom-LKPP/internal/models"
+	"time"
+)
+
+type IdempotencyRepository interface {
+	ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord, staleBefore time.Time) (bool, error)
+	GetIdempotencyRecord(ctx context.Context, principalID, key string) (*models.IdempotencyRecord, error)
+	CompleteIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error
+	ReleaseIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error)
+}
+
+type idempotencyRepository struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+func NewIdempotencyRepository(db *sql.DB, queryTimeout time.Duration) IdempotencyRepository {
+	return &idempotencyRepository{
+		DB:           db,
+		QueryTimeout: queryTimeout,
+	}
+}
+
+// ReserveIdempotencyKey claims the key for a new request and reports whether
+// it succeeded. A key whose record has expired is claimed again, and so is a
+// reservation made before staleBefore that never completed, as its request
+// must have died with its process.
+func (repo *idempotencyRepository) ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord, staleBefore time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	result, err := repo.DB.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (principal_id, idempotency_key, request_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (principal_id, idempotency_key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = NULL, response_body = NULL,
+			created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+			OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at <= $6)
+	`, record.PrincipalID, record.Key, record.RequestHash, record.CreatedAt, record.ExpiresAt, staleBefore)
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (repo *idempotencyRepository) GetIdempotencyRecord(ctx context.Context, principalID, key string) (*models.IdempotencyRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	var record models.IdempotencyRecord
+	var statusCode sql.NullInt64
+	var contentType sql.NullString
+
+	err := repo.DB.QueryRowContext(ctx, `
+		SELECT principal_id, idempotency_key, request_hash, status_code, content_type, response_body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE principal_id = $1 AND idempotency_key = $2
+	`, principalID, key).Scan(
+		&record.PrincipalID,
+		&record.Key,
+		&record.RequestHash,
+		&statusCode,
+		&contentType,
+		&record.Body,
+		&record.CreatedAt,
+		&record.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	record.StatusCode = int(statusCode.Int64)
+	record.ContentType = contentType.String
+
+	return &record, nil
+}
+
+// CompleteIdempotencyKey stores the response to replay on retries. The
+// creation time identifies the reservation, so a request whose reservation
+// was reclaimed does not overwrite the newer one.
+func (repo *idempotencyRepository) CompleteIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, `
+		UPDATE idempotency_keys SET status_code = $1, content_type = $2, response_body = $3
+		WHERE principal_id = $4 AND idempotency_key = $5 AND created_at = $6
+	`, record.StatusCode, record.ContentType, record.Body, record.PrincipalID, record.Key, record.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets a key whose request did not complete, so
+// that it can be retried
+func (repo *idempotencyRepository) ReleaseIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE principal_id = $1 AND idempotency_key = $2 AND created_at = $3 AND status_code IS NULL
+	`, record.PrincipalID, record.Key, record.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *idempotencyRepository) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	result, err := repo.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+
+	return result.RowsAffected()
+}
@@ -3,6 +3,7 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -10,48 +11,119 @@ import (
 	"product-catalogue-Telkom-LKPP/internal/models"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/google/uuid"
 )
 
+// ErrDuplicateSKU is returned when the seller already has a product with the SKU
+var ErrDuplicateSKU = errors.New("seller already has a product with this SKU")
+
+// ErrNoSearchFilter is returned when a product search sets none of its filters
+var ErrNoSearchFilter = errors.New("at least one search filter is required")
+
+// productSellerSKUConstraint is the unique index keeping SKUs unique per seller
+const productSellerSKUConstraint = "products_seller_sku_key"
+
+// ProductRepository reads and writes products within a tenant scope, so a
+// seller never sees another seller's unpublished products or edits them
 type ProductRepository interface {
-	GetProductByID(productID string) (*models.Product, error)
-	SearchProducts(query *models.ProductQuery, page, perPage int) ([]*models.Product, error)
-	CreateProduct(product *models.Product) error
-	UpdateProduct(productID string, product *models.Product) error
+	GetProductByID(ctx context.Context, productID uuid.UUID, scope models.TenantScope) (*models.Product, error)
+	GetProductsBySKU(ctx context.Context, sku, sellerID string, scope models.TenantScope) ([]*models.Product, error)
+	SearchProducts(ctx context.Context, query *models.ProductQuery, page, perPage int, scope models.TenantScope) ([]*models.Product, error)
+	CreateProduct(ctx context.Context, product *models.Product, beforeCommit BeforeCommit) error
+	UpdateProduct(ctx context.Context, productID uuid.UUID, product *models.Product, scope models.TenantScope, beforeCommit BeforeCommit) (replaced []*models.ProductImage, err error)
+	MoveProductEtalase(ctx context.Context, productID uuid.UUID, etalase string, scope models.TenantScope) error
+	GetImageProduct(ctx context.Context, imageID uuid.UUID) (sellerID string, published bool, err error)
 }
 
 type productRepository struct {
-	DB *sql.DB
+	DB           *sql.DB
+	QueryTimeout time.Duration
 }
 
-func NewProductRepository(db *sql.DB) ProductRepository {
-	return &productRepository{
-		DB: db,
+func NewProductRepository(db *sql.DB, queryTimeout time.Duration) ProductRepository {
+	return &tracedProductRepository{
+		repo: &productRepository{
+			DB:           db,
+			QueryTimeout: queryTimeout,
+		},
 	}
 }
 
-func (repo *productRepository) GetProductByID(productID string) (*models.Product, error) {
+func (repo *productRepository) GetProductByID(ctx context.Context, productID uuid.UUID, scope models.TenantScope) (*models.Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
 	// Prepare the SQL statement
 	query := `
 			SELECT
-					p.id, p.sku, p.title, p.description, p.category, p.etalase, p.images, p.weight, p.price,
-					COALESCE(AVG(pr.rating),0) as rating
+					p.id, COALESCE(p.seller_id, ''), p.sku, p.title, p.description, p.category, p.etalase, p.images, p.weight, p.price,
+					COALESCE(AVG(pr.rating),0) as rating, p.published
+				FROM
+					products p
+				LEFT JOIN
+					product_reviews pr on p.id = pr.product_id
+			WHERE
+				p.id = $1 AND (p.published OR $2 OR p.seller_id = $3)
+			GROUP BY p.id
+	`
+
+	return scanProduct(repo.DB.QueryRowContext(ctx, query, productID, scope.Unrestricted, scope.SellerID))
+}
+
+// GetProductsBySKU returns the products visible in scope that use the SKU,
+// optionally only the one of sellerID. SKUs are unique per seller, so
+// several sellers may share one.
+func (repo *productRepository) GetProductsBySKU(ctx context.Context, sku, sellerID string, scope models.TenantScope) ([]*models.Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	query := `
+			SELECT
+					p.id, COALESCE(p.seller_id, ''), p.sku, p.title, p.description, p.category, p.etalase, p.images, p.weight, p.price,
+					COALESCE(AVG(pr.rating),0) as rating, p.published
 				FROM
 					products p
 				LEFT JOIN
 					product_reviews pr on p.id = pr.product_id
 			WHERE
-				p.id = $1
+				p.sku = $1 AND ($2 = '' OR p.seller_id = $2) AND (p.published OR $3 OR p.seller_id = $4)
 			GROUP BY p.id
+			ORDER BY p.created_at
 	`
 
-	row := repo.DB.QueryRow(query, productID)
+	rows, err := repo.DB.QueryContext(ctx, query, sku, sellerID, scope.Unrestricted, scope.SellerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []*models.Product{}
+	for rows.Next() {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		products = append(products, product)
+	}
+
+	return products, rows.Err()
+}
+
+type productScanner interface {
+	Scan(dest ...interface{}) error
+}
 
+func scanProduct(row productScanner) (*models.Product, error) {
 	var product models.Product
 	var imagesJSON []byte
 
 	// Scan the retrieved row into the product struct
 	err := row.Scan(
 		&product.ID,
+		&product.SellerID,
 		&product.SKU,
 		&product.Title,
 		&product.Description,
@@ -61,6 +133,7 @@ func (repo *productRepository) GetProductByID(productID string) (*models.Product
 		&product.Weight,
 		&product.Price,
 		&product.Rating,
+		&product.Published,
 	)
 	if err != nil {
 		return nil, err
@@ -75,11 +148,15 @@ func (repo *productRepository) GetProductByID(productID string) (*models.Product
 	return &product, nil
 }
 
-func (repo *productRepository) SearchProducts(query *models.ProductQuery, page, perPage int) ([]*models.Product, error) {
+func (repo *productRepository) SearchProducts(ctx context.Context, query *models.ProductQuery, page, perPage int, scope models.TenantScope) ([]*models.Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
 	// Prepare the SQL statement
 	sql := `
 	select
 		p.id,
+		COALESCE(p.seller_id, ''),
 		p.sku,
 		p.title,
 		p.description,
@@ -88,7 +165,8 @@ func (repo *productRepository) SearchProducts(query *models.ProductQuery, page,
 		p.images,
 		p.weight,
 		p.price,
-		COALESCE(AVG(pr.rating),0) as rating
+		COALESCE(AVG(pr.rating),0) as rating,
+		p.published
 	from
 		products p
 	left join
@@ -124,10 +202,21 @@ func (repo *productRepository) SearchProducts(query *models.ProductQuery, page,
 		argCounter++
 	}
 
+	if query.SellerID != "" {
+		whereConditions = append(whereConditions, fmt.Sprintf("p.seller_id = $%d", argCounter))
+		args = append(args, query.SellerID)
+		argCounter++
+	}
+
 	if len(whereConditions) == 0 {
-		return nil, errors.New("Invalid query parameters")
+		return nil, ErrNoSearchFilter
 	}
 
+	// Hide unpublished products of other sellers
+	whereConditions = append(whereConditions, fmt.Sprintf("(p.published OR $%d OR p.seller_id = $%d)", argCounter, argCounter+1))
+	args = append(args, scope.Unrestricted, scope.SellerID)
+	argCounter += 2
+
 	sql += strings.Join(whereConditions, " AND ")
 
 	var sortField string
@@ -152,7 +241,7 @@ func (repo *productRepository) SearchProducts(query *models.ProductQuery, page,
 
 	args = append(args, perPage, page*perPage)
 
-	rows, err := repo.DB.Query(sql, args...)
+	rows, err := repo.DB.QueryContext(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -160,61 +249,78 @@ func (repo *productRepository) SearchProducts(query *models.ProductQuery, page,
 
 	products := []*models.Product{}
 	for rows.Next() {
-		var product models.Product
-		var imagesJSON []byte
-
-		err := rows.Scan(
-			&product.ID,
-			&product.SKU,
-			&product.Title,
-			&product.Description,
-			&product.Category,
-			&product.Etalase,
-			&imagesJSON,
-			&product.Weight,
-			&product.Price,
-			&product.Rating,
-		)
+		product, err := scanProduct(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		// Unmarshal JSONB images data into the product.Images slice
-		err = json.Unmarshal(imagesJSON, &product.Images)
-		if err != nil {
-			return nil, err
-		}
-
-		products = append(products, &product)
+		products = append(products, product)
 	}
 
-	return products, nil
+	return products, rows.Err()
 }
 
-func (repo *productRepository) CreateProduct(product *models.Product) error {
+func (repo *productRepository) CreateProduct(ctx context.Context, product *models.Product, beforeCommit BeforeCommit) error {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
 	// Convert images slice to JSONB data
 	imagesJSON, err := json.Marshal(product.Images)
 	if err != nil {
-		return fmt.Errorf("failed to marshal images to JSON: %v", err)
+		return fmt.Errorf("failed to marshal images to JSON: %w", err)
 	}
 
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	// Insert new product record into the database
-	_, err = repo.DB.Exec(`
-		INSERT INTO products (id, sku, title, description, category, etalase, images, weight, price)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-	`, product.ID, product.SKU, product.Title, product.Description, product.Category, product.Etalase, imagesJSON, product.Weight, product.Price)
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO products (id, seller_id, sku, title, description, category, etalase, images, weight, price, published)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`, product.ID, product.SellerID, product.SKU, product.Title, product.Description, product.Category, product.Etalase, imagesJSON, product.Weight, product.Price, product.Published)
 	if err != nil {
-		return fmt.Errorf("failed to insert product: %v", err)
+		if isUniqueViolation(err, productSellerSKUConstraint) {
+			return ErrDuplicateSKU
+		}
+		return fmt.Errorf("failed to insert product: %w", err)
 	}
 
-	return nil
+	return commit(tx, beforeCommit)
 }
 
-func (repo *productRepository) UpdateProduct(productID string, product *models.Product) error {
+func (repo *productRepository) UpdateProduct(ctx context.Context, productID uuid.UUID, product *models.Product, scope models.TenantScope, beforeCommit BeforeCommit) ([]*models.ProductImage, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
 	// Convert images slice to JSONB data
 	imagesJSON, err := json.Marshal(product.Images)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the product and read the images the update replaces
+	var replacedJSON []byte
+	err = tx.QueryRowContext(ctx, `
+		SELECT images FROM products WHERE id = $1 AND ($2 OR seller_id = $3) FOR UPDATE
+	`, productID, scope.Unrestricted, scope.SellerID).Scan(&replacedJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	var replaced []*models.ProductImage
+	if len(replacedJSON) > 0 {
+		if err := json.Unmarshal(replacedJSON, &replaced); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal images JSON: %w", err)
+		}
 	}
 
 	// Prepare the SQL statement
@@ -228,12 +334,13 @@ func (repo *productRepository) UpdateProduct(productID string, product *models.P
 					etalase = $5,
 					images = $6,
 					weight = $7,
-					price = $8
+					price = $8,
+					published = $9
 			WHERE
-					id = $9
+					id = $10 AND ($11 OR seller_id = $12)
 	`
 
-	_, err = repo.DB.Exec(
+	result, err := tx.ExecContext(ctx,
 		query,
 		product.SKU,
 		product.Title,
@@ -243,11 +350,73 @@ func (repo *productRepository) UpdateProduct(productID string, product *models.P
 		imagesJSON,
 		product.Weight,
 		product.Price,
+		product.Published,
 		productID,
+		scope.Unrestricted,
+		scope.SellerID,
 	)
+	if err != nil {
+		if isUniqueViolation(err, productSellerSKUConstraint) {
+			return nil, ErrDuplicateSKU
+		}
+		return nil, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	if err := commit(tx, beforeCommit); err != nil {
+		return nil, err
+	}
+
+	return replaced, nil
+}
+
+func (repo *productRepository) MoveProductEtalase(ctx context.Context, productID uuid.UUID, etalase string, scope models.TenantScope) error {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	result, err := repo.DB.ExecContext(ctx, `
+		UPDATE products SET etalase = $1 WHERE id = $2 AND ($3 OR seller_id = $4)
+	`, etalase, productID, scope.Unrestricted, scope.SellerID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
 
 	return nil
 }
+
+// GetImageProduct returns the seller and publication state of the product the
+// image belongs to, or sql.ErrNoRows when no product uses the image
+func (repo *productRepository) GetImageProduct(ctx context.Context, imageID uuid.UUID) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	var sellerID string
+	var published bool
+
+	err := repo.DB.QueryRowContext(ctx, `
+		SELECT COALESCE(seller_id, ''), published
+		FROM products
+		WHERE images @> jsonb_build_array(jsonb_build_object('id', $1::text))
+		LIMIT 1
+	`, imageID.String()).Scan(&sellerID, &published)
+	if err != nil {
+		return "", false, err
+	}
+
+	return sellerID, published, nil
+}
@@ -0,0 +1,46 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PurchaseVerifier confirms whether a buyer has purchased a product
+type PurchaseVerifier interface {
+	HasPurchased(ctx context.Context, buyerID string, productID uuid.UUID) (bool, error)
+}
+
+type orderPurchaseVerifier struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+// NewOrderPurchaseVerifier returns a PurchaseVerifier backed by the local orders table
+func NewOrderPurchaseVerifier(db *sql.DB, queryTimeout time.Duration) PurchaseVerifier {
+	return &orderPurchaseVerifier{
+		DB:           db,
+		QueryTimeout: queryTimeout,
+	}
+}
+
+func (v *orderPurchaseVerifier) HasPurchased(ctx context.Context, buyerID string, productID uuid.UUID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, v.QueryTimeout)
+	defer cancel()
+
+	var purchased bool
+	err := v.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM orders
+			WHERE buyer_id = $1 AND product_id = $2 AND status = 'completed'
+		)
+	`, buyerID, productID).Scan(&purchased)
+	if err != nil {
+		return false, fmt.Errorf("failed to check purchase: %w", err)
+	}
+
+	return purchased, nil
+}
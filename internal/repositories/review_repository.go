@@ -1,34 +1,304 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"product-catalogue-Telkom-LKPP/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+// ErrDuplicateReview is returned when a reviewer already reviewed the product
+var ErrDuplicateReview = errors.New("reviewer already reviewed this product")
+
 type ReviewRepository interface {
-	CreateReview(review *models.Review) error
+	CreateReview(ctx context.Context, review *models.Review, beforeCommit BeforeCommit) error
+	HasReviewed(ctx context.Context, productID uuid.UUID, reviewerID string) (bool, error)
+	GetReviewByID(ctx context.Context, reviewID uuid.UUID) (*models.Review, error)
+	ListReviews(ctx context.Context, query *models.ReviewQuery, page, perPage int) ([]*models.Review, error)
+	VoteReview(ctx context.Context, vote *models.ReviewVote) error
+	ReplyReview(ctx context.Context, reply *models.ReviewReply) error
+	DeleteReview(ctx context.Context, reviewID uuid.UUID) (deletedImages []*models.ProductImage, err error)
 }
 
 type reviewRepository struct {
-	DB *sql.DB
+	DB           *sql.DB
+	QueryTimeout time.Duration
 }
 
-func NewReviewRepository(db *sql.DB) ReviewRepository {
-	return &reviewRepository{
-		DB: db,
+func NewReviewRepository(db *sql.DB, queryTimeout time.Duration) ReviewRepository {
+	return &tracedReviewRepository{
+		repo: &reviewRepository{
+			DB:           db,
+			QueryTimeout: queryTimeout,
+		},
 	}
 }
 
-func (repo *reviewRepository) CreateReview(review *models.Review) error {
+func (repo *reviewRepository) CreateReview(ctx context.Context, review *models.Review, beforeCommit BeforeCommit) error {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	// Convert images slice to JSONB data
+	imagesJSON, err := json.Marshal(review.Images)
+	if err != nil {
+		return fmt.Errorf("failed to marshal images to JSON: %w", err)
+	}
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	// Insert new review record into the database
-	_, err := repo.DB.Exec(`
-		INSERT INTO product_reviews (id, product_id, rating, review_comment)
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO product_reviews (id, product_id, reviewer_id, reviewer_name, rating, review_comment, images, verified, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, review.ID, review.ProductID, review.ReviewerID, review.ReviewerName, review.Rating, review.Comment, imagesJSON, review.Verified, review.CreatedAt)
+	if err != nil {
+		// The unique (product_id, reviewer_id) index catches concurrent duplicates
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateReview
+		}
+		return fmt.Errorf("failed to insert review: %w", err)
+	}
+
+	return commit(tx, beforeCommit)
+}
+
+func (repo *reviewRepository) HasReviewed(ctx context.Context, productID uuid.UUID, reviewerID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	var exists bool
+	err := repo.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM product_reviews WHERE product_id = $1 AND reviewer_id = $2
+		)
+	`, productID, reviewerID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check existing review: %w", err)
+	}
+
+	return exists, nil
+}
+
+// reviewColumns selects a review together with its vote counts and seller reply
+const reviewColumns = `
+	r.id,
+	r.product_id,
+	COALESCE(r.reviewer_id, ''),
+	COALESCE(r.reviewer_name, ''),
+	r.rating,
+	COALESCE(r.review_comment, ''),
+	COALESCE(r.images, '[]'),
+	r.verified,
+	COALESCE(r.created_at, 'epoch'::timestamptz),
+	COUNT(v.voter_id) FILTER (WHERE v.helpful) as helpful_count,
+	COUNT(v.voter_id) FILTER (WHERE NOT v.helpful) as not_helpful_count,
+	rp.seller_id,
+	rp.reply_comment,
+	rp.created_at
+from
+	product_reviews r
+left join
+	review_votes v on v.review_id = r.id
+left join
+	review_replies rp on rp.review_id = r.id
+`
+
+type reviewScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanReview(row reviewScanner) (*models.Review, error) {
+	var review models.Review
+	var imagesJSON []byte
+	var replySellerID, replyComment sql.NullString
+	var replyCreatedAt sql.NullTime
+
+	err := row.Scan(
+		&review.ID,
+		&review.ProductID,
+		&review.ReviewerID,
+		&review.ReviewerName,
+		&review.Rating,
+		&review.Comment,
+		&imagesJSON,
+		&review.Verified,
+		&review.CreatedAt,
+		&review.HelpfulCount,
+		&review.NotHelpfulCount,
+		&replySellerID,
+		&replyComment,
+		&replyCreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal JSONB images data into the review.Images slice
+	err = json.Unmarshal(imagesJSON, &review.Images)
+	if err != nil {
+		return nil, err
+	}
+
+	// Attach the seller reply only when one was written
+	if replySellerID.Valid {
+		review.Reply = &models.ReviewReply{
+			ReviewID:  review.ID,
+			SellerID:  replySellerID.String,
+			Comment:   replyComment.String,
+			CreatedAt: replyCreatedAt.Time,
+		}
+	}
+
+	return &review, nil
+}
+
+func (repo *reviewRepository) GetReviewByID(ctx context.Context, reviewID uuid.UUID) (*models.Review, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	query := `select` + reviewColumns + `
+	where
+		r.id = $1
+	group by r.id, rp.review_id
+	`
+
+	return scanReview(repo.DB.QueryRowContext(ctx, query, reviewID))
+}
+
+func (repo *reviewRepository) ListReviews(ctx context.Context, query *models.ReviewQuery, page, perPage int) ([]*models.Review, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	var sortField string
+	switch query.SortBy {
+	case "newest":
+		sortField = "r.created_at DESC"
+	case "oldest":
+		sortField = "r.created_at ASC"
+	case "highestRated":
+		sortField = "r.rating DESC, r.created_at DESC"
+	case "lowestRated":
+		sortField = "r.rating ASC, r.created_at DESC"
+	case "mostHelpful":
+		sortField = "helpful_count DESC, not_helpful_count ASC, r.created_at DESC"
+	default:
+		sortField = "r.created_at DESC" // Default to newest
+	}
+
+	sql := `select` + reviewColumns + `
+	where
+		r.product_id = $1
+	group by r.id, rp.review_id
+	ORDER BY ` + sortField + `
+	LIMIT $2
+	OFFSET $3`
+
+	rows, err := repo.DB.QueryContext(ctx, sql, query.ProductID, perPage, page*perPage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []*models.Review{}
+	for rows.Next() {
+		review, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		reviews = append(reviews, review)
+	}
+
+	return reviews, rows.Err()
+}
+
+func (repo *reviewRepository) VoteReview(ctx context.Context, vote *models.ReviewVote) error {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	// A user keeps a single vote per review; voting again replaces it
+	_, err := repo.DB.ExecContext(ctx, `
+		INSERT INTO review_votes (review_id, voter_id, helpful)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (review_id, voter_id) DO UPDATE SET helpful = EXCLUDED.helpful
+	`, vote.ReviewID, vote.VoterID, vote.Helpful)
+	if err != nil {
+		return fmt.Errorf("failed to record review vote: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *reviewRepository) ReplyReview(ctx context.Context, reply *models.ReviewReply) error {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	// A review has a single seller reply; replying again edits it
+	_, err := repo.DB.ExecContext(ctx, `
+		INSERT INTO review_replies (review_id, seller_id, reply_comment, created_at)
 		VALUES ($1, $2, $3, $4)
-	`, review.ID, review.ProductID, review.Rating, review.Comment)
+		ON CONFLICT (review_id) DO UPDATE SET reply_comment = EXCLUDED.reply_comment, created_at = EXCLUDED.created_at
+	`, reply.ReviewID, reply.SellerID, reply.Comment, reply.CreatedAt)
 	if err != nil {
-		return fmt.Errorf("failed to insert review: %v", err)
+		return fmt.Errorf("failed to insert review reply: %w", err)
 	}
 
 	return nil
 }
+
+// DeleteReview removes the review with its votes and reply, and returns the
+// images the review carried so that their files can be removed
+func (repo *reviewRepository) DeleteReview(ctx context.Context, reviewID uuid.UUID) ([]*models.ProductImage, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Remove the rows referencing the review before the review itself
+	_, err = tx.ExecContext(ctx, `DELETE FROM review_votes WHERE review_id = $1`, reviewID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete review votes: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM review_replies WHERE review_id = $1`, reviewID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete review reply: %w", err)
+	}
+
+	var imagesJSON []byte
+	err = tx.QueryRowContext(ctx, `DELETE FROM product_reviews WHERE id = $1 RETURNING images`, reviewID).Scan(&imagesJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete review: %w", err)
+	}
+
+	var images []*models.ProductImage
+	if len(imagesJSON) > 0 {
+		if err := json.Unmarshal(imagesJSON, &images); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal images JSON: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
@@ -0,0 +1,59 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"product-catalogue-Telkom-LKPP/internal/models"
+	"time"
+)
+
+type SellerRepository interface {
+	GetSellerByID(ctx context.Context, sellerID string) (*models.Seller, error)
+	SaveSeller(ctx context.Context, seller *models.Seller) error
+}
+
+type sellerRepository struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+func NewSellerRepository(db *sql.DB, queryTimeout time.Duration) SellerRepository {
+	return &sellerRepository{
+		DB:           db,
+		QueryTimeout: queryTimeout,
+	}
+}
+
+func (repo *sellerRepository) GetSellerByID(ctx context.Context, sellerID string) (*models.Seller, error) {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	var seller models.Seller
+
+	err := repo.DB.QueryRowContext(ctx, `
+		SELECT id, COALESCE(name, ''), created_at FROM sellers WHERE id = $1
+	`, sellerID).Scan(&seller.ID, &seller.Name, &seller.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &seller, nil
+}
+
+func (repo *sellerRepository) SaveSeller(ctx context.Context, seller *models.Seller) error {
+	ctx, cancel := context.WithTimeout(ctx, repo.QueryTimeout)
+	defer cancel()
+
+	// Register the seller on first use and keep the display name current
+	_, err := repo.DB.ExecContext(ctx, `
+		INSERT INTO sellers (id, name)
+		VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET name = COALESCE(NULLIF(EXCLUDED.name, ''), sellers.name)
+	`, seller.ID, seller.Name)
+	if err != nil {
+		return fmt.Errorf("failed to save seller: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+// internal/repositories/tracing.go
+
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"product-catalogue-Telkom-LKPP/internal/models"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("product-catalogue-Telkom-LKPP/internal/repositories")
+
+// startSpan starts a client span named after the SQL statement run against table
+func startSpan(ctx context.Context, table, statement string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, table+"."+statement,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBSQLTable(table),
+			semconv.DBOperation(statement),
+		),
+	)
+}
+
+// endSpan ends the span, marking it failed unless err only means "not found"
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedProductRepository records a span around every product query
+type tracedProductRepository struct {
+	repo *productRepository
+}
+
+func (t *tracedProductRepository) GetProductByID(ctx context.Context, productID uuid.UUID, scope models.TenantScope) (*models.Product, error) {
+	ctx, span := startSpan(ctx, "products", "get_product_by_id")
+	product, err := t.repo.GetProductByID(ctx, productID, scope)
+	endSpan(span, err)
+	return product, err
+}
+
+func (t *tracedProductRepository) GetProductsBySKU(ctx context.Context, sku, sellerID string, scope models.TenantScope) ([]*models.Product, error) {
+	ctx, span := startSpan(ctx, "products", "get_products_by_sku")
+	products, err := t.repo.GetProductsBySKU(ctx, sku, sellerID, scope)
+	endSpan(span, err)
+	return products, err
+}
+
+func (t *tracedProductRepository) SearchProducts(ctx context.Context, query *models.ProductQuery, page, perPage int, scope models.TenantScope) ([]*models.Product, error) {
+	ctx, span := startSpan(ctx, "products", "search_products")
+	products, err := t.repo.SearchProducts(ctx, query, page, perPage, scope)
+	span.SetAttributes(attribute.Int("db.rows_returned", len(products)))
+	endSpan(span, err)
+	return products, err
+}
+
+func (t *tracedProductRepository) CreateProduct(ctx context.Context, product *models.Product, beforeCommit BeforeCommit) error {
+	ctx, span := startSpan(ctx, "products", "create_product")
+	err := t.repo.CreateProduct(ctx, product, beforeCommit)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedProductRepository) UpdateProduct(ctx context.Context, productID uuid.UUID, product *models.Product, scope models.TenantScope, beforeCommit BeforeCommit) ([]*models.ProductImage, error) {
+	ctx, span := startSpan(ctx, "products", "update_product")
+	replaced, err := t.repo.UpdateProduct(ctx, productID, product, scope, beforeCommit)
+	endSpan(span, err)
+	return replaced, err
+}
+
+func (t *tracedProductRepository) MoveProductEtalase(ctx context.Context, productID uuid.UUID, etalase string, scope models.TenantScope) error {
+	ctx, span := startSpan(ctx, "products", "move_product_etalase")
+	err := t.repo.MoveProductEtalase(ctx, productID, etalase, scope)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedProductRepository) GetImageProduct(ctx context.Context, imageID uuid.UUID) (string, bool, error) {
+	ctx, span := startSpan(ctx, "products", "get_image_product")
+	sellerID, published, err := t.repo.GetImageProduct(ctx, imageID)
+	endSpan(span, err)
+	return sellerID, published, err
+}
+
+// tracedReviewRepository records a span around every review query
+type tracedReviewRepository struct {
+	repo *reviewRepository
+}
+
+func (t *tracedReviewRepository) CreateReview(ctx context.Context, review *models.Review, beforeCommit BeforeCommit) error {
+	ctx, span := startSpan(ctx, "product_reviews", "create_review")
+	err := t.repo.CreateReview(ctx, review, beforeCommit)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedReviewRepository) HasReviewed(ctx context.Context, productID uuid.UUID, reviewerID string) (bool, error) {
+	ctx, span := startSpan(ctx, "product_reviews", "has_reviewed")
+	reviewed, err := t.repo.HasReviewed(ctx, productID, reviewerID)
+	endSpan(span, err)
+	return reviewed, err
+}
+
+func (t *tracedReviewRepository) GetReviewByID(ctx context.Context, reviewID uuid.UUID) (*models.Review, error) {
+	ctx, span := startSpan(ctx, "product_reviews", "get_review_by_id")
+	review, err := t.repo.GetReviewByID(ctx, reviewID)
+	endSpan(span, err)
+	return review, err
+}
+
+func (t *tracedReviewRepository) ListReviews(ctx context.Context, query *models.ReviewQuery, page, perPage int) ([]*models.Review, error) {
+	ctx, span := startSpan(ctx, "product_reviews", "list_reviews")
+	reviews, err := t.repo.ListReviews(ctx, query, page, perPage)
+	endSpan(span, err)
+	return reviews, err
+}
+
+func (t *tracedReviewRepository) VoteReview(ctx context.Context, vote *models.ReviewVote) error {
+	ctx, span := startSpan(ctx, "review_votes", "vote_review")
+	err := t.repo.VoteReview(ctx, vote)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedReviewRepository) ReplyReview(ctx context.Context, reply *models.ReviewReply) error {
+	ctx, span := startSpan(ctx, "review_replies", "reply_review")
+	err := t.repo.ReplyReview(ctx, reply)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedReviewRepository) DeleteReview(ctx context.Context, reviewID uuid.UUID) ([]*models.ProductImage, error) {
+	ctx, span := startSpan(ctx, "product_reviews", "delete_review")
+	images, err := t.repo.DeleteReview(ctx, reviewID)
+	endSpan(span, err)
+	return images, err
+}
@@ -0,0 +1,192 @@
+// internal/server/idempotency.go
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"product-catalogue-Telkom-LKPP/internal/auth"
+	"product-catalogue-Telkom-LKPP/internal/config"
+	"product-catalogue-Telkom-LKPP/internal/logging"
+	"product-catalogue-Telkom-LKPP/internal/models"
+	"product-catalogue-Telkom-LKPP/internal/problem"
+	"product-catalogue-Telkom-LKPP/internal/repositories"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// statusClientClosedRequest is the status answered to a client that went
+// away; like server errors, it is never replayed
+const statusClientClosedRequest = 499
+
+// validIdempotencyKey accepts printable ASCII keys such as UUIDs
+var validIdempotencyKey = regexp.MustCompile(`^[\x21-\x7E]{1,255}$`)
+
+// idempotency makes the POST requests it is mounted on safe to retry when
+// sent with an Idempotency-Key header: the first response is stored for the
+// configured TTL and replayed to retries with the same body, while reusing
+// the key for a different request is rejected. Server errors and requests
+// the client abandoned are not stored, so such requests can be retried for
+// real, and a reservation left in progress for longer than the lease is
+// given to the next retry.
+func idempotency(store repositories.IdempotencyRepository, cfg config.IdempotencyConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			principal, authenticated := auth.FromContext(r.Context())
+			if r.Method != http.MethodPost || key == "" || !authenticated {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !validIdempotencyKey.MatchString(key) {
+				problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidIdempotencyKey, "Idempotency-Key must be 1 to 255 printable ASCII characters")
+				return
+			}
+
+			// Read the body to fingerprint the request, then hand it on
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					problem.Write(w, r, http.StatusRequestEntityTooLarge, problem.CodeRequestTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+					return
+				}
+				problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidJSON, "Failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			// PostgreSQL keeps microseconds, and the creation time must
+			// round-trip to identify the reservation
+			now := time.Now().UTC().Truncate(time.Microsecond)
+			record := &models.IdempotencyRecord{
+				PrincipalID: principal.ID,
+				Key:         key,
+				RequestHash: requestHash(r, body),
+				CreatedAt:   now,
+				ExpiresAt:   now.Add(cfg.TTL),
+			}
+
+			reserved, err := store.ReserveIdempotencyKey(r.Context(), record, now.Add(-cfg.Lease))
+			if err != nil {
+				writeIdempotencyError(w, r, err)
+				return
+			}
+			if !reserved {
+				replay(w, r, store, record)
+				return
+			}
+
+			// Serve the request while keeping a copy of the response
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			var response bytes.Buffer
+			ww.Tee(&response)
+
+			// Settle the key even if the client has gone away meanwhile
+			ctx := context.WithoutCancel(r.Context())
+			defer func() {
+				if rec := recover(); rec != nil {
+					releaseIdempotencyKey(ctx, store, record)
+					panic(rec)
+				}
+			}()
+
+			next.ServeHTTP(ww, r)
+
+			record.StatusCode = ww.Status()
+			if record.StatusCode == 0 {
+				record.StatusCode = http.StatusOK
+			}
+
+			// A cancelled request may have been cut short before it took
+			// effect, so its retry must run again
+			if record.StatusCode >= statusClientClosedRequest || r.Context().Err() != nil {
+				releaseIdempotencyKey(ctx, store, record)
+				return
+			}
+
+			record.ContentType = ww.Header().Get("Content-Type")
+			record.Body = response.Bytes()
+			if err := store.CompleteIdempotencyKey(ctx, record); err != nil {
+				logging.FromContext(ctx).Error("failed to store idempotent response", "error", err)
+				releaseIdempotencyKey(ctx, store, record)
+			}
+		})
+	}
+}
+
+// replay answers a retry with the stored response of the original request
+func replay(w http.ResponseWriter, r *http.Request, store repositories.IdempotencyRepository, record *models.IdempotencyRecord) {
+	original, err := store.GetIdempotencyRecord(r.Context(), record.PrincipalID, record.Key)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		writeIdempotencyError(w, r, err)
+		return
+	}
+
+	// The original request failed or expired between the two queries, and
+	// the retry may take the key over
+	if errors.Is(err, sql.ErrNoRows) {
+		writeIdempotencyKeyInUse(w, r)
+		return
+	}
+
+	// A different request can never be answered with this key, even while
+	// the original one is still running
+	if original.RequestHash != record.RequestHash {
+		problem.Write(w, r, http.StatusUnprocessableEntity, problem.CodeIdempotencyKeyReused, "Idempotency-Key was already used for a different request")
+		return
+	}
+
+	if original.StatusCode == 0 {
+		writeIdempotencyKeyInUse(w, r)
+		return
+	}
+
+	if original.ContentType != "" {
+		w.Header().Set("Content-Type", original.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(original.StatusCode)
+	w.Write(original.Body)
+}
+
+// requestHash fingerprints the method, path and body of a request
+func requestHash(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// writeIdempotencyKeyInUse asks the client to retry once the original request
+// has settled
+func writeIdempotencyKeyInUse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+	problem.Write(w, r, http.StatusConflict, problem.CodeIdempotencyKeyInUse, "A request with this Idempotency-Key is still being processed")
+}
+
+func releaseIdempotencyKey(ctx context.Context, store repositories.IdempotencyRepository, record *models.IdempotencyRecord) {
+	if err := store.ReleaseIdempotencyKey(ctx, record); err != nil {
+		logging.FromContext(ctx).Error("failed to release idempotency key", "error", err)
+	}
+}
+
+func writeIdempotencyError(w http.ResponseWriter, r *http.Request, err error) {
+	logging.FromContext(r.Context()).Error("failed to look up idempotency key", "error", err)
+	if repositories.IsTimeout(err) {
+		problem.Write(w, r, http.StatusGatewayTimeout, problem.CodeQueryTimeout, "Timed out checking the Idempotency-Key")
+		return
+	}
+	problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Failed to check the Idempotency-Key")
+}
@@ -0,0 +1,425 @@
+// internal/server/idempotency_test.go
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"product-catalogue-Telkom-LKPP/internal/auth"
+	"product-catalogue-Telkom-LKPP/internal/config"
+	"product-catalogue-Telkom-LKPP/internal/models"
+)
+
+// fakeIdempotencyStore keeps idempotency records in memory, following the
+// rules of the PostgreSQL repository
+type fakeIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]models.IdempotencyRecord
+}
+
+func newFakeIdempotencyStore() *fakeIdempotencyStore {
+	return &fakeIdempotencyStore{records: map[string]models.IdempotencyRecord{}}
+}
+
+func (s *fakeIdempotencyStore) ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord, staleBefore time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := record.PrincipalID + "|" + record.Key
+	if existing, found := s.records[id]; found {
+		expired := !existing.ExpiresAt.After(record.CreatedAt)
+		stale := existing.StatusCode == 0 && !existing.CreatedAt.After(staleBefore)
+		if !expired && !stale {
+			return false, nil
+		}
+	}
+
+	s.records[id] = models.IdempotencyRecord{
+		PrincipalID: record.PrincipalID,
+		Key:         record.Key,
+		RequestHash: record.RequestHash,
+		CreatedAt:   record.CreatedAt,
+		ExpiresAt:   record.ExpiresAt,
+	}
+	return true, nil
+}
+
+func (s *fakeIdempotencyStore) GetIdempotencyRecord(ctx context.Context, principalID, key string) (*models.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, found := s.records[principalID+"|"+key]
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+	return &record, nil
+}
+
+func (s *fakeIdempotencyStore) CompleteIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := record.PrincipalID + "|" + record.Key
+	if existing, found := s.records[id]; found && existing.CreatedAt.Equal(record.CreatedAt) {
+		existing.StatusCode = record.StatusCode
+		existing.ContentType = record.ContentType
+		existing.Body = append([]byte(nil), record.Body...)
+		s.records[id] = existing
+	}
+	return nil
+}
+
+func (s *fakeIdempotencyStore) ReleaseIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := record.PrincipalID + "|" + record.Key
+	if existing, found := s.records[id]; found && existing.CreatedAt.Equal(record.CreatedAt) && existing.StatusCode == 0 {
+		delete(s.records, id)
+	}
+	return nil
+}
+
+func (s *fakeIdempotencyStore) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+const (
+	testPrincipalID    = "seller-1"
+	testIdempotencyKey = "3f1c2b7e-8d4a-4c1e-9b6f-2a5d7e9c0b1a"
+	testPath           = "/products/"
+	testBody           = `{"title":"Kopi"}`
+)
+
+var testIdempotencyConfig = config.IdempotencyConfig{TTL: time.Hour, Lease: 2 * time.Minute}
+
+// newIdempotentRequest returns an authenticated POST request carrying the
+// Idempotency-Key header
+func newIdempotentRequest(ctx context.Context, key, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, testPath, strings.NewReader(body)).WithContext(ctx)
+	if key != "" {
+		r.Header.Set("Idempotency-Key", key)
+	}
+	return r.WithContext(auth.NewContext(r.Context(), &auth.Principal{ID: testPrincipalID}))
+}
+
+// seedRecord returns a record for testIdempotencyKey as the middleware would
+// have stored it for body
+func seedRecord(body string, statusCode int, createdAt time.Time) models.IdempotencyRecord {
+	return models.IdempotencyRecord{
+		PrincipalID: testPrincipalID,
+		Key:         testIdempotencyKey,
+		RequestHash: requestHash(httptest.NewRequest(http.MethodPost, testPath, nil), []byte(body)),
+		StatusCode:  statusCode,
+		ContentType: "text/plain; charset=utf-8",
+		Body:        []byte("original response"),
+		CreatedAt:   createdAt,
+		ExpiresAt:   createdAt.Add(testIdempotencyConfig.TTL),
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name          string
+		seed          *models.IdempotencyRecord
+		key           string
+		body          string
+		handlerStatus int  // Status answered by the wrapped handler
+		cancel        bool // The client goes away while the handler runs
+		wantStatus    int
+		wantBody      string
+		wantHeaders   map[string]string
+		wantCalls     int
+		wantStored    int // Status of the stored record afterwards, -1 when there is none
+	}{
+		{
+			name:          "first request is served and stored",
+			key:           testIdempotencyKey,
+			body:          testBody,
+			handlerStatus: http.StatusCreated,
+			wantStatus:    http.StatusCreated,
+			wantBody:      "handled",
+			wantCalls:     1,
+			wantStored:    http.StatusCreated,
+		},
+		{
+			name:          "request without a key is not stored",
+			body:          testBody,
+			handlerStatus: http.StatusCreated,
+			wantStatus:    http.StatusCreated,
+			wantBody:      "handled",
+			wantCalls:     1,
+			wantStored:    -1,
+		},
+		{
+			name:       "invalid key",
+			key:        "key with spaces",
+			body:       testBody,
+			wantStatus: http.StatusBadRequest,
+			wantStored: -1,
+		},
+		{
+			name:       "completed request is replayed",
+			seed:       ptr(seedRecord(testBody, http.StatusCreated, now.Add(-time.Minute))),
+			key:        testIdempotencyKey,
+			body:       testBody,
+			wantStatus: http.StatusCreated,
+			wantBody:   "original response",
+			wantHeaders: map[string]string{
+				"Content-Type":        "text/plain; charset=utf-8",
+				"Idempotent-Replayed": "true",
+			},
+			wantStored: http.StatusCreated,
+		},
+		{
+			name:       "completed client error is replayed",
+			seed:       ptr(seedRecord(testBody, http.StatusUnprocessableEntity, now.Add(-time.Minute))),
+			key:        testIdempotencyKey,
+			body:       testBody,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "original response",
+			wantStored: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "different body after completion",
+			seed:       ptr(seedRecord(testBody, http.StatusCreated, now.Add(-time.Minute))),
+			key:        testIdempotencyKey,
+			body:       `{"title":"Teh"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantStored: http.StatusCreated,
+		},
+		{
+			name:       "different body while in progress",
+			seed:       ptr(seedRecord(testBody, 0, now.Add(-time.Second))),
+			key:        testIdempotencyKey,
+			body:       `{"title":"Teh"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantStored: 0,
+		},
+		{
+			name:        "same body while in progress",
+			seed:        ptr(seedRecord(testBody, 0, now.Add(-time.Second))),
+			key:         testIdempotencyKey,
+			body:        testBody,
+			wantStatus:  http.StatusConflict,
+			wantHeaders: map[string]string{"Retry-After": "1"},
+			wantStored:  0,
+		},
+		{
+			name:          "stale lease is taken over",
+			seed:          ptr(seedRecord(testBody, 0, now.Add(-time.Hour+time.Minute))),
+			key:           testIdempotencyKey,
+			body:          testBody,
+			handlerStatus: http.StatusCreated,
+			wantStatus:    http.StatusCreated,
+			wantBody:      "handled",
+			wantCalls:     1,
+			wantStored:    http.StatusCreated,
+		},
+		{
+			name:          "expired record is reused",
+			seed:          ptr(seedRecord(`{"title":"Teh"}`, http.StatusCreated, now.Add(-2*time.Hour))),
+			key:           testIdempotencyKey,
+			body:          testBody,
+			handlerStatus: http.StatusCreated,
+			wantStatus:    http.StatusCreated,
+			wantBody:      "handled",
+			wantCalls:     1,
+			wantStored:    http.StatusCreated,
+		},
+		{
+			name:          "server error releases the key",
+			key:           testIdempotencyKey,
+			body:          testBody,
+			handlerStatus: http.StatusInternalServerError,
+			wantStatus:    http.StatusInternalServerError,
+			wantBody:      "handled",
+			wantCalls:     1,
+			wantStored:    -1,
+		},
+		{
+			name:          "cancelled request releases the key",
+			key:           testIdempotencyKey,
+			body:          testBody,
+			handlerStatus: http.StatusCreated,
+			cancel:        true,
+			wantStatus:    http.StatusCreated,
+			wantBody:      "handled",
+			wantCalls:     1,
+			wantStored:    -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeIdempotencyStore()
+			if tt.seed != nil {
+				store.records[tt.seed.PrincipalID+"|"+tt.seed.Key] = *tt.seed
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			calls := 0
+			handler := idempotency(store, testIdempotencyConfig)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if tt.cancel {
+					cancel()
+				}
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.WriteHeader(tt.handlerStatus)
+				w.Write([]byte("handled"))
+			}))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, newIdempotentRequest(ctx, tt.key, tt.body))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			for header, want := range tt.wantHeaders {
+				if got := rec.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("handler called %d times, want %d", calls, tt.wantCalls)
+			}
+
+			stored, err := store.GetIdempotencyRecord(context.Background(), testPrincipalID, testIdempotencyKey)
+			switch {
+			case tt.wantStored < 0 && err == nil:
+				t.Errorf("key still stored with status %d, want it released", stored.StatusCode)
+			case tt.wantStored >= 0 && err != nil:
+				t.Errorf("key not stored, want status %d", tt.wantStored)
+			case tt.wantStored >= 0 && stored.StatusCode != tt.wantStored:
+				t.Errorf("stored status = %d, want %d", stored.StatusCode, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestIdempotencyRetriesReleasedKey(t *testing.T) {
+	tests := []struct {
+		name          string
+		handlerStatus int
+		cancel        bool
+	}{
+		{name: "after a server error", handlerStatus: http.StatusServiceUnavailable},
+		{name: "after a gateway timeout", handlerStatus: http.StatusGatewayTimeout},
+		{name: "after the client went away", handlerStatus: http.StatusCreated, cancel: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeIdempotencyStore()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			calls := 0
+			handler := idempotency(store, testIdempotencyConfig)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls == 1 {
+					if tt.cancel {
+						cancel()
+					}
+					w.WriteHeader(tt.handlerStatus)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("created"))
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), newIdempotentRequest(ctx, testIdempotencyKey, testBody))
+
+			retry := httptest.NewRecorder()
+			handler.ServeHTTP(retry, newIdempotentRequest(context.Background(), testIdempotencyKey, testBody))
+			if retry.Code != http.StatusCreated || retry.Body.String() != "created" {
+				t.Fatalf("retry got %d %q, want 201 \"created\"", retry.Code, retry.Body.String())
+			}
+			if retry.Header().Get("Idempotent-Replayed") != "" {
+				t.Error("retry was replayed instead of served")
+			}
+			if calls != 2 {
+				t.Errorf("handler called %d times, want 2", calls)
+			}
+
+			// The retry's response is now the one replayed
+			replayed := httptest.NewRecorder()
+			handler.ServeHTTP(replayed, newIdempotentRequest(context.Background(), testIdempotencyKey, testBody))
+			if replayed.Code != http.StatusCreated || replayed.Body.String() != "created" || replayed.Header().Get("Idempotent-Replayed") != "true" {
+				t.Errorf("second retry got %d %q, want the replayed 201 \"created\"", replayed.Code, replayed.Body.String())
+			}
+			if calls != 2 {
+				t.Errorf("handler called %d times after the replay, want 2", calls)
+			}
+		})
+	}
+}
+
+// TestIdempotencyLeaseTakeover checks that a request whose reservation was
+// taken over after its lease ran out does not overwrite the newer response
+func TestIdempotencyLeaseTakeover(t *testing.T) {
+	store := newFakeIdempotencyStore()
+	cfg := config.IdempotencyConfig{TTL: time.Hour, Lease: time.Nanosecond}
+
+	retried := make(chan struct{})
+	slow := idempotency(store, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-retried
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("slow"))
+	}))
+	fast := idempotency(store, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("fast"))
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		slow.ServeHTTP(httptest.NewRecorder(), newIdempotentRequest(context.Background(), testIdempotencyKey, testBody))
+	}()
+
+	// Wait for the slow request to reserve the key, then take it over
+	for {
+		if _, err := store.GetIdempotencyRecord(context.Background(), testPrincipalID, testIdempotencyKey); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(time.Millisecond)
+
+	takeover := httptest.NewRecorder()
+	fast.ServeHTTP(takeover, newIdempotentRequest(context.Background(), testIdempotencyKey, testBody))
+	if takeover.Code != http.StatusCreated || takeover.Body.String() != "fast" {
+		t.Fatalf("takeover got %d %q, want 201 \"fast\"", takeover.Code, takeover.Body.String())
+	}
+
+	close(retried)
+	<-done
+
+	stored, err := store.GetIdempotencyRecord(context.Background(), testPrincipalID, testIdempotencyKey)
+	if err != nil {
+		t.Fatalf("GetIdempotencyRecord: %v", err)
+	}
+	if string(stored.Body) != "fast" {
+		t.Errorf("stored body = %q, want the response of the request holding the key", stored.Body)
+	}
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
@@ -3,16 +3,66 @@
 package server
 
 import (
+	"log/slog"
 	"net/http"
 
+	"product-catalogue-Telkom-LKPP/internal/auth"
+	"product-catalogue-Telkom-LKPP/internal/config"
 	"product-catalogue-Telkom-LKPP/internal/handlers"
+	"product-catalogue-Telkom-LKPP/internal/problem"
+	"product-catalogue-Telkom-LKPP/internal/repositories"
 
 	"github.com/go-chi/chi"
 )
 
-func NewRouter(productHandler *handlers.ProductHandler, reviewHandler *handlers.ReviewHandler) http.Handler {
+// Handlers groups the HTTP handlers mounted by the router
+type Handlers struct {
+	Product *handlers.ProductHandler
+	Review  *handlers.ReviewHandler
+	Seller  *handlers.SellerHandler
+	APIKey  *handlers.APIKeyHandler
+	Health  *handlers.HealthHandler
+}
+
+func NewRouter(h Handlers, logger *slog.Logger, metricsHandler http.Handler, jwtValidator *auth.JWTValidator, apiKeys auth.APIKeyStore, idempotencyKeys repositories.IdempotencyRepository, idempotencyCfg config.IdempotencyConfig, maxBodyBytes int64) http.Handler {
 	r := chi.NewRouter()
 
+	// Trace every request, tag it with an ID, log it once served and log panics
+	r.Use(traceRequests)
+	r.Use(requestID(logger))
+	r.Use(accessLog)
+	r.Use(recordMetrics)
+	r.Use(recoverer)
+
+	// Answer unknown routes and methods with problem details too
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "No route matches "+r.URL.Path)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+	})
+
+	// Health probes and metrics stay outside authentication and body limits
+	r.Get("/healthz", h.Health.Liveness)
+	r.Get("/readyz", h.Health.Readiness)
+	r.Method(http.MethodGet, "/metrics", metricsHandler)
+
+	r.Group(func(r chi.Router) {
+		// Cap the size of every request body
+		r.Use(limitBody(maxBodyBytes))
+
+		// Authenticate API keys and bearer tokens; only GET endpoints are public
+		r.Use(auth.Middleware(jwtValidator, apiKeys))
+
+		// Replay the stored response when product or review creation is
+		// retried with its Idempotency-Key. Other routes are left out, as
+		// responses such as new API keys must not be stored.
+		mountRoutes(r, h, idempotency(idempotencyKeys, idempotencyCfg))
+	})
+	return r
+}
+
+func mountRoutes(r chi.Router, h Handlers, idempotent func(http.Handler) http.Handler) {
 	// Add a handler for the root path
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/plain")
@@ -21,16 +71,56 @@ func NewRouter(productHandler *handlers.ProductHandler, reviewHandler *handlers.
 
 	// Group the routes under "/products"
 	r.Route("/products", func(productRouter chi.Router) {
-		productRouter.Post("/", productHandler.CreateProduct)
-		productRouter.Put("/{productID}", productHandler.UpdateProduct)
-		productRouter.Get("/", productHandler.SearchProducts)
-		productRouter.Get("/{productID}", productHandler.GetProduct)
-		productRouter.Get("/images/{imageID}", productHandler.ServeImage)
+		productRouter.With(idempotent).Post("/", h.Product.CreateProduct)
+		productRouter.Put("/{productID}", h.Product.UpdateProduct)
+		productRouter.Put("/{productID}/etalase", h.Product.MoveProductEtalase)
+		productRouter.Get("/", h.Product.SearchProducts)
+		productRouter.Get("/{productID}", h.Product.GetProduct)
+		productRouter.Get("/sku/{sku}", h.Product.GetProductBySKU)
+		productRouter.Get("/images/{imageID}", h.Product.ServeImage)
+	})
+
+	// Group the routes under "/sellers"
+	r.Route("/sellers", func(sellerRouter chi.Router) {
+		sellerRouter.Get("/{sellerID}", h.Seller.GetSeller)
 	})
 
 	// Group the routes under "/reviews"
 	r.Route("/review", func(reviewRouter chi.Router) {
-		reviewRouter.Post("/", reviewHandler.CreateReview)
+		reviewRouter.With(idempotent).Post("/", h.Review.CreateReview)
+		reviewRouter.Get("/", h.Review.ListReviews)
+		reviewRouter.Post("/{reviewID}/vote", h.Review.VoteReview)
+		reviewRouter.Post("/{reviewID}/reply", h.Review.ReplyReview)
+		reviewRouter.Delete("/{reviewID}", h.Review.ModerateReview)
 	})
-	return r
+
+	// Group the routes under "/admin"
+	r.Route("/admin", func(adminRouter chi.Router) {
+		adminRouter.Post("/api-keys", h.APIKey.CreateAPIKey)
+		adminRouter.Get("/api-keys", h.APIKey.ListAPIKeys)
+		adminRouter.Delete("/api-keys/{keyID}", h.APIKey.RevokeAPIKey)
+	})
+}
+
+func limitBody(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// NewHTTPServer returns the HTTP server serving the router with the
+// configured timeouts and header size limit
+func NewHTTPServer(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
 }
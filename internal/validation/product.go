@@ -0,0 +1,75 @@
+// internal/validation/product.go
+
+package validation
+
+import (
+	"regexp"
+	"strings"
+
+	"product-catalogue-Telkom-LKPP/internal/models"
+)
+
+const (
+	maxSKULength         = 50
+	maxTitleLength       = 255
+	maxDescriptionLength = 5000
+	maxCategoryLength    = 50
+	maxEtalaseLength     = 50
+
+	// maxAmount is the largest value a DECIMAL(10, 2) column holds
+	maxAmount = 99999999.99
+)
+
+var skuPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+var productRequestSchema = Schema[*models.ProductRequest]{
+	Check("sku", func(p *models.ProductRequest) string { return p.SKU },
+		Required(),
+		MaxLength(maxSKULength),
+		Matches(skuPattern, "may only contain letters, digits, '.', '_' and '-', starting with a letter or digit"),
+	),
+	Check("title", func(p *models.ProductRequest) string { return p.Title },
+		Required(),
+		MaxLength(maxTitleLength),
+	),
+	Check("description", func(p *models.ProductRequest) string { return p.Description },
+		MaxLength(maxDescriptionLength),
+	),
+	Check("category", func(p *models.ProductRequest) string { return p.Category },
+		MaxLength(maxCategoryLength),
+	),
+	Check("etalase", func(p *models.ProductRequest) string { return p.Etalase },
+		MaxLength(maxEtalaseLength),
+	),
+	Check("weight", func(p *models.ProductRequest) float64 { return p.Weight },
+		Between(0, maxAmount),
+	),
+	Check("price", func(p *models.ProductRequest) float64 { return p.Price },
+		Between(0, maxAmount),
+	),
+}
+
+var etalaseRequestSchema = Schema[*models.EtalaseRequest]{
+	Check("etalase", func(e *models.EtalaseRequest) string { return e.Etalase },
+		Required(),
+		MaxLength(maxEtalaseLength),
+	),
+}
+
+// ProductRequest trims the text fields of a product about to be created,
+// updated or imported and returns every field that fails validation
+func ProductRequest(request *models.ProductRequest) []models.FieldError {
+	request.SKU = strings.TrimSpace(request.SKU)
+	request.Title = strings.TrimSpace(request.Title)
+	request.Category = strings.TrimSpace(request.Category)
+	request.Etalase = strings.TrimSpace(request.Etalase)
+
+	return productRequestSchema.Validate(request)
+}
+
+// EtalaseRequest trims the etalase a product moves to and validates it
+func EtalaseRequest(request *models.EtalaseRequest) []models.FieldError {
+	request.Etalase = strings.TrimSpace(request.Etalase)
+
+	return etalaseRequestSchema.Validate(request)
+}
@@ -0,0 +1,178 @@
+// internal/validation/product_test.go
+
+package validation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"product-catalogue-Telkom-LKPP/internal/models"
+)
+
+func validProductRequest() *models.ProductRequest {
+	return &models.ProductRequest{
+		SKU:         "KOPI-250.g_1",
+		Title:       "Kopi Arabika 250 g",
+		Description: "Biji kopi sangrai",
+		Category:    "Minuman",
+		Etalase:     "Kopi",
+		Weight:      0.25,
+		Price:       75000,
+	}
+}
+
+func TestProductRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*models.ProductRequest)
+		want   []models.FieldError
+	}{
+		{
+			name:   "valid",
+			change: func(p *models.ProductRequest) {},
+		},
+		{
+			name: "limits are inclusive",
+			change: func(p *models.ProductRequest) {
+				p.SKU = strings.Repeat("A", maxSKULength)
+				p.Title = strings.Repeat("t", maxTitleLength)
+				p.Description = strings.Repeat("d", maxDescriptionLength)
+				p.Category = strings.Repeat("c", maxCategoryLength)
+				p.Etalase = strings.Repeat("e", maxEtalaseLength)
+				p.Weight = maxAmount
+				p.Price = 0
+			},
+		},
+		{
+			name:   "optional fields may be empty",
+			change: func(p *models.ProductRequest) { p.Description, p.Category, p.Etalase, p.Weight = "", "", "", 0 },
+		},
+		{
+			name:   "missing SKU and title",
+			change: func(p *models.ProductRequest) { p.SKU, p.Title = "", "" },
+			want: []models.FieldError{
+				{Field: "sku", Message: "is required"},
+				{Field: "title", Message: "is required"},
+			},
+		},
+		{
+			name:   "blank SKU and title",
+			change: func(p *models.ProductRequest) { p.SKU, p.Title = "   ", "\t" },
+			want: []models.FieldError{
+				{Field: "sku", Message: "is required"},
+				{Field: "title", Message: "is required"},
+			},
+		},
+		{
+			name: "text one character too long",
+			change: func(p *models.ProductRequest) {
+				p.SKU = strings.Repeat("A", maxSKULength+1)
+				p.Title = strings.Repeat("t", maxTitleLength+1)
+				p.Description = strings.Repeat("d", maxDescriptionLength+1)
+				p.Category = strings.Repeat("c", maxCategoryLength+1)
+				p.Etalase = strings.Repeat("e", maxEtalaseLength+1)
+			},
+			want: []models.FieldError{
+				{Field: "sku", Message: "must be at most 50 characters"},
+				{Field: "title", Message: "must be at most 255 characters"},
+				{Field: "description", Message: "must be at most 5000 characters"},
+				{Field: "category", Message: "must be at most 50 characters"},
+				{Field: "etalase", Message: "must be at most 50 characters"},
+			},
+		},
+		{
+			name:   "SKU with disallowed characters",
+			change: func(p *models.ProductRequest) { p.SKU = "KOPI 250" },
+			want: []models.FieldError{
+				{Field: "sku", Message: "may only contain letters, digits, '.', '_' and '-', starting with a letter or digit"},
+			},
+		},
+		{
+			name:   "SKU starting with punctuation",
+			change: func(p *models.ProductRequest) { p.SKU = "-KOPI" },
+			want: []models.FieldError{
+				{Field: "sku", Message: "may only contain letters, digits, '.', '_' and '-', starting with a letter or digit"},
+			},
+		},
+		{
+			name:   "negative weight and price",
+			change: func(p *models.ProductRequest) { p.Weight, p.Price = -0.01, -1 },
+			want: []models.FieldError{
+				{Field: "weight", Message: "must be between 0 and 99999999.99"},
+				{Field: "price", Message: "must be between 0 and 99999999.99"},
+			},
+		},
+		{
+			name:   "price beyond DECIMAL(10, 2)",
+			change: func(p *models.ProductRequest) { p.Price = 100000000 },
+			want: []models.FieldError{
+				{Field: "price", Message: "must be between 0 and 99999999.99"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := validProductRequest()
+			tt.change(request)
+
+			if got := ProductRequest(request); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ProductRequest = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProductRequestTrimsText(t *testing.T) {
+	request := validProductRequest()
+	request.SKU = "  KOPI-1 "
+	request.Title = " " + strings.Repeat("t", maxTitleLength) + " "
+	request.Category = "\tMinuman\n"
+	request.Etalase = " Kopi "
+
+	// Surrounding blanks neither count towards the limits nor break the SKU pattern
+	if fieldErrors := ProductRequest(request); len(fieldErrors) > 0 {
+		t.Fatalf("ProductRequest = %+v, want no errors", fieldErrors)
+	}
+
+	if request.SKU != "KOPI-1" || request.Category != "Minuman" || request.Etalase != "Kopi" || len(request.Title) != maxTitleLength {
+		t.Errorf("request not trimmed: %+v", request)
+	}
+}
+
+func TestEtalaseRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		etalase     string
+		wantEtalase string
+		want        []models.FieldError
+	}{
+		{name: "valid", etalase: " Kopi ", wantEtalase: "Kopi"},
+		{name: "at the limit", etalase: strings.Repeat("e", maxEtalaseLength), wantEtalase: strings.Repeat("e", maxEtalaseLength)},
+		{
+			name:    "blank",
+			etalase: "   ",
+			want:    []models.FieldError{{Field: "etalase", Message: "is required"}},
+		},
+		{
+			name:        "too long",
+			etalase:     strings.Repeat("e", maxEtalaseLength+1),
+			wantEtalase: strings.Repeat("e", maxEtalaseLength+1),
+			want:        []models.FieldError{{Field: "etalase", Message: "must be at most 50 characters"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := &models.EtalaseRequest{Etalase: tt.etalase}
+
+			if got := EtalaseRequest(request); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EtalaseRequest = %+v, want %+v", got, tt.want)
+			}
+			if request.Etalase != tt.wantEtalase {
+				t.Errorf("etalase = %q, want %q", request.Etalase, tt.wantEtalase)
+			}
+		})
+	}
+}
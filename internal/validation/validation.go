@@ -0,0 +1,104 @@
+// internal/validation/validation.go
+
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"product-catalogue-Telkom-LKPP/internal/models"
+)
+
+// Rule checks a value and returns a message describing the violation, or an
+// empty string when the value is valid
+type Rule[V any] func(value V) string
+
+// Field validates one field of T; build it with Check
+type Field[T any] struct {
+	name  string
+	check func(T) string
+}
+
+// Check declares the rules of the named field, reading its value with get.
+// The rules run in order and the first violation is reported.
+func Check[T, V any](name string, get func(T) V, rules ...Rule[V]) Field[T] {
+	return Field[T]{
+		name: name,
+		check: func(target T) string {
+			value := get(target)
+			for _, rule := range rules {
+				if message := rule(value); message != "" {
+					return message
+				}
+			}
+			return ""
+		},
+	}
+}
+
+// Schema is the list of field rules of T
+type Schema[T any] []Field[T]
+
+// Validate returns every field of target that breaks a rule
+func (s Schema[T]) Validate(target T) []models.FieldError {
+	var fieldErrors []models.FieldError
+	for _, field := range s {
+		if message := field.check(target); message != "" {
+			fieldErrors = append(fieldErrors, models.FieldError{Field: field.name, Message: message})
+		}
+	}
+	return fieldErrors
+}
+
+// Required rejects empty and blank strings
+func Required() Rule[string] {
+	return func(value string) string {
+		if strings.TrimSpace(value) == "" {
+			return "is required"
+		}
+		return ""
+	}
+}
+
+// MaxLength rejects strings longer than max characters
+func MaxLength(max int) Rule[string] {
+	return func(value string) string {
+		if utf8.RuneCountInString(value) > max {
+			return fmt.Sprintf("must be at most %d characters", max)
+		}
+		return ""
+	}
+}
+
+// Matches rejects non-empty strings not matching pattern, explaining the
+// allowed characters with description
+func Matches(pattern *regexp.Regexp, description string) Rule[string] {
+	return func(value string) string {
+		if value != "" && !pattern.MatchString(value) {
+			return description
+		}
+		return ""
+	}
+}
+
+// Number is the set of types the range rules apply to
+type Number interface {
+	~int | ~int64 | ~float64
+}
+
+// Between rejects numbers outside [min, max]
+func Between[V Number](min, max V) Rule[V] {
+	return func(value V) string {
+		if value < min || value > max {
+			return "must be between " + formatNumber(min) + " and " + formatNumber(max)
+		}
+		return ""
+	}
+}
+
+func formatNumber[V Number](value V) string {
+	return strconv.FormatFloat(float64(value), 'f', -1, 64)
+}
@@ -0,0 +1,169 @@
+// internal/validation/validation_test.go
+
+package validation
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"product-catalogue-Telkom-LKPP/internal/models"
+)
+
+func TestRequired(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "a"},
+		{value: " a "},
+		{value: "", want: "is required"},
+		{value: "   ", want: "is required"},
+		{value: "\t\n", want: "is required"},
+	}
+
+	for _, tt := range tests {
+		if got := Required()(tt.value); got != tt.want {
+			t.Errorf("Required()(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMaxLength(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: ""},
+		{value: "abc"},
+		{value: "abcd", want: "must be at most 3 characters"},
+		{value: "ééé"}, // Characters are counted, not bytes
+		{value: "éééé", want: "must be at most 3 characters"},
+	}
+
+	for _, tt := range tests {
+		if got := MaxLength(3)(tt.value); got != tt.want {
+			t.Errorf("MaxLength(3)(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	rule := Matches(regexp.MustCompile(`^[a-z]+$`), "may only contain lowercase letters")
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: ""}, // Left to Required
+		{value: "abc"},
+		{value: "aBc", want: "may only contain lowercase letters"},
+		{value: "a c", want: "may only contain lowercase letters"},
+	}
+
+	for _, tt := range tests {
+		if got := rule(tt.value); got != tt.want {
+			t.Errorf("Matches(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestBetween(t *testing.T) {
+	floatTests := []struct {
+		value float64
+		want  string
+	}{
+		{value: 0},
+		{value: 0.5},
+		{value: 99.99},
+		{value: -0.01, want: "must be between 0 and 99.99"},
+		{value: 100, want: "must be between 0 and 99.99"},
+	}
+	for _, tt := range floatTests {
+		if got := Between(0, 99.99)(tt.value); got != tt.want {
+			t.Errorf("Between(0, 99.99)(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	intTests := []struct {
+		value int
+		want  string
+	}{
+		{value: 1},
+		{value: 5},
+		{value: 0, want: "must be between 1 and 5"},
+		{value: 6, want: "must be between 1 and 5"},
+	}
+	for _, tt := range intTests {
+		if got := Between(1, 5)(tt.value); got != tt.want {
+			t.Errorf("Between(1, 5)(%d) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	type pair struct {
+		name  string
+		count int
+	}
+
+	calls := 0
+	counted := func(value string) string {
+		calls++
+		return ""
+	}
+	schema := Schema[pair]{
+		Check("name", func(p pair) string { return p.name }, Required(), MaxLength(3), counted),
+		Check("count", func(p pair) int { return p.count }, Between(1, 9)),
+	}
+
+	tests := []struct {
+		name      string
+		target    pair
+		want      []models.FieldError
+		wantCalls int
+	}{
+		{
+			name:      "valid",
+			target:    pair{name: "abc", count: 1},
+			wantCalls: 1,
+		},
+		{
+			name:   "every field is reported",
+			target: pair{name: "abcd", count: 10},
+			want: []models.FieldError{
+				{Field: "name", Message: "must be at most 3 characters"},
+				{Field: "count", Message: "must be between 1 and 9"},
+			},
+		},
+		{
+			name:   "only the first violation of a field is reported",
+			target: pair{name: "", count: 5},
+			want: []models.FieldError{
+				{Field: "name", Message: "is required"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			got := schema.Validate(tt.target)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate = %+v, want %+v", got, tt.want)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("last name rule ran %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	if got := formatNumber(99999999.99); got != "99999999.99" {
+		t.Errorf("formatNumber = %q, want 99999999.99", got)
+	}
+	if got := formatNumber(int64(1) << 40); got != "1099511627776" {
+		t.Errorf("formatNumber = %q, want 1099511627776", got)
+	}
+}
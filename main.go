@@ -1,30 +1,203 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"product-catalogue-Telkom-LKPP/internal/auth"
+	"product-catalogue-Telkom-LKPP/internal/config"
 	"product-catalogue-Telkom-LKPP/internal/handlers"
+	"product-catalogue-Telkom-LKPP/internal/logging"
+	"product-catalogue-Telkom-LKPP/internal/metrics"
+	"product-catalogue-Telkom-LKPP/internal/migrations"
 	"product-catalogue-Telkom-LKPP/internal/repositories"
 	"product-catalogue-Telkom-LKPP/internal/server"
+	"product-catalogue-Telkom-LKPP/internal/tracing"
+	"syscall"
+	"time"
 )
 
 func main() {
+	if err := run(); err != nil {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	// Load the configuration from the environment and the optional config file
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("loading configuration: %v", err)
+	}
+
+	// Log structured records in the configured format
+	logger, err := logging.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		return fmt.Errorf("configuring logging: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	// Export traces and accept W3C traceparent headers
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		return fmt.Errorf("configuring tracing: %v", err)
+	}
+	defer func() {
+		// Flush the spans still buffered when the server stops
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("failed to flush traces", "error", err)
+		}
+	}()
+
 	// Create a database connection
-	db, err := repositories.NewDBConnection()
+	db, err := repositories.NewDBConnection(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("connecting to the database: %v", err)
+	}
+	defer db.Close() // Close the database connection once every request has finished
+
+	// Run the "migrate" subcommand instead of the server when asked to
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(db, os.Args[2:]); err != nil {
+			return fmt.Errorf("migrating the database: %v", err)
+		}
+		return nil
+	}
+
+	migrator, err := migrations.NewMigrator(db)
+	if err != nil {
+		return fmt.Errorf("loading migrations: %v", err)
+	}
+
+	// Bring the schema up to date before serving requests
+	if cfg.Database.AutoMigrate {
+		if _, err := migrator.Up(); err != nil {
+			return fmt.Errorf("migrating the database: %v", err)
+		}
+	}
+
+	imageStore := handlers.NewImageStore(cfg)
+
+	// Drop images staged by requests that were cut short by a previous stop
+	if removed, err := imageStore.PurgeStaged(time.Hour); err != nil {
+		logger.Warn("failed to purge staged images", "error", err)
+	} else if removed > 0 {
+		logger.Info("purged staged images", "count", removed)
+	}
+
+	productRepo := repositories.NewProductRepository(db, cfg.Database.QueryTimeout)
+	sellerRepo := repositories.NewSellerRepository(db, cfg.Database.QueryTimeout)
+	productHandler := handlers.NewProductHandler(productRepo, sellerRepo, imageStore)
+	sellerHandler := handlers.NewSellerHandler(sellerRepo)
+
+	apiKeyRepo := repositories.NewAPIKeyRepository(db, cfg.Database.QueryTimeout)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyRepo, sellerRepo)
+
+	idempotencyRepo := repositories.NewIdempotencyRepository(db, cfg.Database.QueryTimeout)
+
+	reviewRepo := repositories.NewReviewRepository(db, cfg.Database.QueryTimeout)
+	purchaseVerifier := repositories.NewOrderPurchaseVerifier(db, cfg.Database.QueryTimeout)
+	reviewHandler := handlers.NewReviewHandler(reviewRepo, productRepo, purchaseVerifier, imageStore)
+
+	// Load the keys used to verify bearer tokens
+	jwtValidator, err := auth.NewJWTValidator(auth.JWTConfig{
+		HMACSecret:       cfg.Auth.JWTHS256Secret,
+		RSAPublicKeyFile: cfg.Auth.JWTRS256PublicKeyFile,
+		JWKSFile:         cfg.Auth.JWTJWKSFile,
+		Issuer:           cfg.Auth.JWTIssuer,
+		Audience:         cfg.Auth.JWTAudience,
+	})
 	if err != nil {
-		fmt.Printf("Error connecting to the database: %v\n", err)
-		return // Stop the application
+		return fmt.Errorf("configuring authentication: %v", err)
 	}
-	defer db.Close() // Close the database connection when the application exits
 
-	productRepo := repositories.NewProductRepository(db)
-	productHandler := handlers.NewProductHandler(productRepo)
+	healthHandler := handlers.NewHealthHandler(db, imageStore, migrator)
+	metricsHandler := metrics.Handler(metrics.NewRegistry(db))
 
-	reviewRepo := repositories.NewReviewRepository(db)
-	reviewHandler := handlers.NewReviewHandler(reviewRepo)
+	router := server.NewRouter(server.Handlers{
+		Product: productHandler,
+		Review:  reviewHandler,
+		Seller:  sellerHandler,
+		APIKey:  apiKeyHandler,
+		Health:  healthHandler,
+	}, logger, metricsHandler, jwtValidator, apiKeyRepo, idempotencyRepo, cfg.Idempotency, cfg.Images.MaxBodyBytes)
+	httpServer := server.NewHTTPServer(cfg.Server, router)
 
-	router := server.NewRouter(productHandler, reviewHandler)
+	// Stop accepting connections on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	fmt.Println("Server is running properly")
-	http.ListenAndServe(":8080", router)
+	// Forget idempotency keys once they expire
+	go purgeIdempotencyKeys(ctx, idempotencyRepo, time.Hour)
+
+	listener, err := net.Listen("tcp", cfg.Server.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %v", cfg.Server.ListenAddr, err)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.Serve(listener)
+	}()
+	logger.Info("server is running", "addr", listener.Addr().String())
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("listening on %s: %v", cfg.Server.ListenAddr, err)
+	case <-ctx.Done():
+	}
+	stop() // A second signal terminates immediately
+
+	// Fail readiness first so load balancers stop sending new traffic
+	healthHandler.SetShuttingDown()
+	if cfg.Server.ShutdownDelay > 0 {
+		logger.Info("shutting down, waiting for load balancers to deregister", "delay", cfg.Server.ShutdownDelay.String())
+		time.Sleep(cfg.Server.ShutdownDelay)
+	}
+
+	// Let in-flight requests such as uploads finish within the drain period
+	logger.Info("shutting down, draining requests", "timeout", cfg.Server.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down: %v", err)
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("listening on %s: %v", cfg.Server.ListenAddr, err)
+	}
+
+	logger.Info("server stopped")
+	return nil
+}
+
+// purgeIdempotencyKeys deletes expired idempotency keys every interval until
+// ctx is done
+func purgeIdempotencyKeys(ctx context.Context, repo repositories.IdempotencyRepository, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := repo.DeleteExpiredIdempotencyKeys(ctx, now)
+			if err != nil {
+				slog.Error("failed to purge idempotency keys", "error", err)
+				continue
+			}
+			if deleted > 0 {
+				slog.Info("purged expired idempotency keys", "count", deleted)
+			}
+		}
+	}
 }